@@ -0,0 +1,173 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/authenticatorfactory"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	clientset "k8s.io/client-go/kubernetes"
+	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/cmd/app/config"
+	"k8s.io/kubernetes/pkg/auth/authorizer/abac"
+	"k8s.io/kubernetes/pkg/kubelet/server"
+)
+
+// BuildAuth creates an authenticator, an authorizer, and a matching authorizer attributes getter compatible with the kubelet's needs
+// It returns AuthInterface, a run method to start internal controllers (like cert and policy file reloading) and error.
+func BuildAuth(nodeName types.NodeName, client clientset.Interface, config config.Config) (server.AuthInterface, func(<-chan struct{}), error) {
+	// Get clients, if provided
+	var (
+		tokenClient authenticationclient.AuthenticationV1Interface
+		sarClient   authorizationclient.AuthorizationV1Interface
+	)
+	if client != nil && !reflect.ValueOf(client).IsNil() {
+		tokenClient = client.AuthenticationV1()
+		sarClient = client.AuthorizationV1()
+	}
+
+	authenticator, runAuthenticatorCAReload, err := BuildAuthn(tokenClient, config.Authentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attributes := server.NewNodeAuthorizerAttributesGetter(nodeName)
+
+	authorizer, runAuthorizerPolicyReload, err := BuildAuthz(sarClient, config.Authorization)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return server.NewKubeletAuth(authenticator, attributes, authorizer), func(stopCh <-chan struct{}) {
+		runAuthenticatorCAReload(stopCh)
+		runAuthorizerPolicyReload(stopCh)
+	}, nil
+}
+
+// BuildAuthn creates an authenticator compatible with the kubelet's needs
+func BuildAuthn(client authenticationclient.AuthenticationV1Interface, authn config.KubeletAuthentication) (authenticator.Request, func(<-chan struct{}), error) {
+	var dynamicCAContentFromFile *dynamiccertificates.DynamicFileCAContent
+	var err error
+	if len(authn.X509.ClientCAFile) > 0 {
+		dynamicCAContentFromFile, err = dynamiccertificates.NewDynamicCAContentFromFile("client-ca-bundle", authn.X509.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	authenticatorConfig := authenticatorfactory.DelegatingAuthenticatorConfig{
+		Anonymous: authn.Anonymous.Enabled,
+		CacheTTL:  authn.Webhook.CacheTTL.Duration,
+	}
+	// Assigning a nil *DynamicFileCAContent would produce a non-nil interface and enable x509 authentication without a CA.
+	if dynamicCAContentFromFile != nil {
+		authenticatorConfig.ClientCertificateCAContentProvider = dynamicCAContentFromFile
+	}
+
+	if authn.Webhook.Enabled {
+		if client == nil {
+			return nil, nil, errors.New("no client provided, cannot use webhook authentication")
+		}
+		authenticatorConfig.WebhookRetryBackoff = defaultAuthWebhookRetryBackoff()
+		authenticatorConfig.TokenAccessReviewClient = client
+	}
+
+	authenticator, _, err := authenticatorConfig.New()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authenticator, func(stopCh <-chan struct{}) {
+		// generate a context from stopCh. This is to avoid modifying files which are relying on this method
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-stopCh:
+				cancel() // stopCh closed, so cancel our context
+			case <-ctx.Done():
+			}
+		}()
+		if dynamicCAContentFromFile != nil {
+			go dynamicCAContentFromFile.Run(ctx, 1)
+		}
+	}, err
+}
+
+// BuildAuthz creates an authorizer compatible with the kubelet's needs
+// It returns the authorizer, a run method to start reloading the ABAC policy file and error.
+func BuildAuthz(client authorizationclient.AuthorizationV1Interface, authz config.KubeletAuthorization) (authorizer.Authorizer, func(<-chan struct{}), error) {
+	noop := func(<-chan struct{}) {}
+
+	switch authz.Mode {
+	case config.KubeletAuthorizationModeAlwaysAllow:
+		return authorizerfactory.NewAlwaysAllowAuthorizer(), noop, nil
+
+	case config.KubeletAuthorizationModeWebhook:
+		if client == nil {
+			return nil, nil, errors.New("no client provided, cannot use webhook authorization")
+		}
+		authorizerConfig := authorizerfactory.DelegatingAuthorizerConfig{
+			SubjectAccessReviewClient: client,
+			AllowCacheTTL:             authz.Webhook.CacheAuthorizedTTL.Duration,
+			DenyCacheTTL:              authz.Webhook.CacheUnauthorizedTTL.Duration,
+			WebhookRetryBackoff:       defaultAuthWebhookRetryBackoff(),
+		}
+		authorizer, err := authorizerConfig.New()
+		return authorizer, noop, err
+
+	case config.KubeletAuthorizationModeABAC:
+		if len(authz.ABAC.PolicyFile) == 0 {
+			return nil, nil, errors.New("no policy file provided, cannot use ABAC authorization")
+		}
+		authorizer, err := abac.NewPolicyFileAuthorizer(authz.ABAC.PolicyFile, klog.Level(authz.ABAC.DecisionLogLevel))
+		if err != nil {
+			return nil, nil, err
+		}
+		return authorizer, func(stopCh <-chan struct{}) {
+			go authorizer.Run(stopCh)
+		}, nil
+
+	case "":
+		return nil, nil, fmt.Errorf("no authorization mode specified")
+
+	default:
+		return nil, nil, fmt.Errorf("unknown authorization mode %s", authz.Mode)
+	}
+}
+
+// defaultAuthWebhookRetryBackoff is the same backoff the generic apiserver options use for
+// the authentication and authorization webhooks.
+func defaultAuthWebhookRetryBackoff() *wait.Backoff {
+	return &wait.Backoff{
+		Duration: 500 * time.Millisecond,
+		Factor:   1.5,
+		Jitter:   0.2,
+		Steps:    5,
+	}
+}
@@ -0,0 +1,475 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"k8s.io/kubernetes/cmd/app/config"
+	"k8s.io/kubernetes/pkg/kubelet/server"
+)
+
+const testNodeName = "test-node"
+
+// fakeReviewServer is an apiserver that only answers TokenReviews and
+// SubjectAccessReviews, and counts how many of each it was sent.
+type fakeReviewServer struct {
+	// tokens maps the bearer tokens the server accepts to their user.
+	tokens map[string]string
+	// allowed are the users the server authorizes.
+	allowed map[string]bool
+
+	tokenReviews         int32
+	subjectAccessReviews int32
+
+	lock sync.Mutex
+	// subresources are the node subresources of the SubjectAccessReviews
+	// the server was sent, in order.
+	subresources []string
+}
+
+func (f *fakeReviewServer) reviewedSubresources() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.subresources...)
+}
+
+func (f *fakeReviewServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var response interface{}
+	switch req.URL.Path {
+	case "/apis/authentication.k8s.io/v1/tokenreviews":
+		atomic.AddInt32(&f.tokenReviews, 1)
+		review := &authenticationv1.TokenReview{}
+		if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if username, ok := f.tokens[review.Spec.Token]; ok {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: username}
+		}
+		review.APIVersion, review.Kind = "authentication.k8s.io/v1", "TokenReview"
+		response = review
+	case "/apis/authorization.k8s.io/v1/subjectaccessreviews":
+		atomic.AddInt32(&f.subjectAccessReviews, 1)
+		review := &authorizationv1.SubjectAccessReview{}
+		if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		attrs := review.Spec.ResourceAttributes
+		if attrs == nil || attrs.Resource != "nodes" || attrs.Name != testNodeName {
+			http.Error(w, "unexpected resource attributes", http.StatusBadRequest)
+			return
+		}
+		f.lock.Lock()
+		f.subresources = append(f.subresources, attrs.Subresource)
+		f.lock.Unlock()
+		review.Status.Allowed = f.allowed[review.Spec.User]
+		review.APIVersion, review.Kind = "authorization.k8s.io/v1", "SubjectAccessReview"
+		response = review
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// fakeHost serves an empty node.
+type fakeHost struct{}
+
+func (fakeHost) GetPods() []*v1.Pod                          { return nil }
+func (fakeHost) GetRunningPods() ([]*v1.Pod, error)          { return nil, nil }
+func (fakeHost) GetPodByName(string, string) (*v1.Pod, bool) { return nil, false }
+func (fakeHost) Healthz() error                              { return nil }
+func (fakeHost) GetKubeletContainerLogs(ctx context.Context, podFullName, containerName string, logOptions *v1.PodLogOptions, stdout, stderr io.Writer) error {
+	return nil
+}
+func (fakeHost) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	return nil
+}
+func (fakeHost) AttachContainer(name string, uid types.UID, container string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	return nil
+}
+func (fakeHost) PortForward(name string, uid types.UID, port int32, stream io.ReadWriteCloser) error {
+	return nil
+}
+
+// fakeSummaryProvider serves an empty stats summary.
+type fakeSummaryProvider struct{}
+
+func (fakeSummaryProvider) Get() (*statsapi.Summary, error) { return &statsapi.Summary{}, nil }
+func (fakeSummaryProvider) GetCPUAndMemoryStats() (*statsapi.Summary, error) {
+	return &statsapi.Summary{}, nil
+}
+
+// testCA is a certificate authority for client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// writeFile writes the CA certificate to a PEM file in dir.
+func (ca *testCA) writeFile(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "ca.crt")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clientCert issues a client certificate for user.
+func (ca *testCA) clientCert(t *testing.T, user string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: user},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newTestConfig returns a kubelet config that authenticates with client
+// certificates from ca and webhook tokens, and authorizes with the webhook.
+func newTestConfig(t *testing.T, ca *testCA, anonymous bool) *config.Config {
+	dir := t.TempDir()
+	return &config.Config{
+		NodeName:      testNodeName,
+		CertDirectory: dir,
+		Authentication: config.KubeletAuthentication{
+			X509:      config.KubeletX509Authentication{ClientCAFile: ca.writeFile(t, dir)},
+			Webhook:   config.KubeletWebhookAuthentication{Enabled: true, CacheTTL: metav1.Duration{Duration: 2 * time.Minute}},
+			Anonymous: config.KubeletAnonymousAuthentication{Enabled: anonymous},
+		},
+		Authorization: config.KubeletAuthorization{
+			Mode: config.KubeletAuthorizationModeWebhook,
+			Webhook: config.KubeletWebhookAuthorization{
+				CacheAuthorizedTTL:   metav1.Duration{Duration: 5 * time.Minute},
+				CacheUnauthorizedTTL: metav1.Duration{Duration: 30 * time.Second},
+			},
+		},
+	}
+}
+
+// startKubeletServer serves the kubelet API over TLS with the auth built from
+// cfg, sending reviews to the fake apiserver.
+func startKubeletServer(t *testing.T, cfg *config.Config, reviewer *fakeReviewServer) string {
+	t.Helper()
+	api := httptest.NewServer(reviewer)
+	t.Cleanup(api.Close)
+	client := kubernetes.NewForConfigOrDie(&restclient.Config{Host: api.URL})
+
+	auth, runAuthReload, err := BuildAuth(types.NodeName(cfg.NodeName), client, *cfg)
+	if err != nil {
+		t.Fatalf("failed to build auth: %v", err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	runAuthReload(stopCh)
+
+	tlsOptions, err := initializeTLS(cfg)
+	if err != nil {
+		t.Fatalf("failed to initialize TLS: %v", err)
+	}
+	s := server.NewServer(fakeHost{}, fakeSummaryProvider{}, auth)
+	ts := httptest.NewUnstartedServer(&s)
+	ts.TLS = tlsOptions.Config
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// get requests path as a client presenting cert and token, if set, and
+// returns the response status code and body.
+func get(t *testing.T, url, path string, cert *tls.Certificate, token string) (int, string) {
+	t.Helper()
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	req, err := http.NewRequest("GET", url+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestBuildAuth(t *testing.T) {
+	ca := newTestCA(t)
+	validCert := ca.clientCert(t, "cert-user")
+	rejectedCert := newTestCA(t).clientCert(t, "cert-user")
+
+	tests := []struct {
+		name      string
+		anonymous bool
+		cert      *tls.Certificate
+		token     string
+
+		expectCode                 int
+		expectBody                 string
+		expectTokenReviews         int32
+		expectSubjectAccessReviews int32
+	}{
+		{
+			name:                       "valid client certificate",
+			cert:                       &validCert,
+			expectCode:                 http.StatusOK,
+			expectSubjectAccessReviews: 1,
+		},
+		{
+			name:       "client certificate from an unknown CA",
+			cert:       &rejectedCert,
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name:                       "allowed bearer token",
+			token:                      "alice-token",
+			expectCode:                 http.StatusOK,
+			expectTokenReviews:         1,
+			expectSubjectAccessReviews: 1,
+		},
+		{
+			name:                       "denied bearer token",
+			token:                      "bob-token",
+			expectCode:                 http.StatusForbidden,
+			expectBody:                 "Forbidden (user=bob, verb=get, resource=nodes, subresource=proxy)",
+			expectTokenReviews:         1,
+			expectSubjectAccessReviews: 1,
+		},
+		{
+			name:               "invalid bearer token",
+			token:              "invalid-token",
+			expectCode:         http.StatusUnauthorized,
+			expectTokenReviews: 1,
+		},
+		{
+			name:                       "anonymous allowed",
+			anonymous:                  true,
+			expectCode:                 http.StatusForbidden,
+			expectBody:                 "Forbidden (user=system:anonymous, verb=get, resource=nodes, subresource=proxy)",
+			expectSubjectAccessReviews: 1,
+		},
+		{
+			name:       "anonymous not allowed",
+			anonymous:  false,
+			expectCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reviewer := &fakeReviewServer{
+				tokens:  map[string]string{"alice-token": "alice", "bob-token": "bob"},
+				allowed: map[string]bool{"alice": true, "cert-user": true},
+			}
+			url := startKubeletServer(t, newTestConfig(t, ca, tc.anonymous), reviewer)
+
+			code, body := get(t, url, "/pods", tc.cert, tc.token)
+			if code != tc.expectCode {
+				t.Errorf("expected status %d, got %d: %s", tc.expectCode, code, body)
+			}
+			if tc.expectBody != "" && !strings.Contains(body, tc.expectBody) {
+				t.Errorf("expected body to contain %q, got %q", tc.expectBody, body)
+			}
+			if got := atomic.LoadInt32(&reviewer.tokenReviews); got != tc.expectTokenReviews {
+				t.Errorf("expected %d token reviews, got %d", tc.expectTokenReviews, got)
+			}
+			if got := atomic.LoadInt32(&reviewer.subjectAccessReviews); got != tc.expectSubjectAccessReviews {
+				t.Errorf("expected %d subject access reviews, got %d", tc.expectSubjectAccessReviews, got)
+			}
+		})
+	}
+}
+
+func TestBuildAuthSubresources(t *testing.T) {
+	ca := newTestCA(t)
+	allowedCert := ca.clientCert(t, "cert-user")
+	deniedCert := ca.clientCert(t, "other-user")
+
+	tests := []struct {
+		name string
+		path string
+		cert *tls.Certificate
+
+		expectSubresource string
+		expectForbidden   bool
+	}{
+		{
+			name:              "stats summary",
+			path:              "/stats/summary",
+			cert:              &allowedCert,
+			expectSubresource: "stats",
+		},
+		{
+			name:              "metrics",
+			path:              "/metrics",
+			cert:              &allowedCert,
+			expectSubresource: "metrics",
+		},
+		{
+			name:              "resource metrics",
+			path:              "/metrics/resource",
+			cert:              &allowedCert,
+			expectSubresource: "metrics",
+		},
+		{
+			name:              "container logs",
+			path:              "/containerLogs/default/pod/container",
+			cert:              &allowedCert,
+			expectSubresource: "log",
+		},
+		{
+			// Paths outside every registered prefix are answered with 404
+			// by the mux before they reach the auth filter.
+			name:              "unknown path",
+			path:              "/pods/unknown",
+			cert:              &allowedCert,
+			expectSubresource: "proxy",
+		},
+		{
+			name:              "denied stats summary",
+			path:              "/stats/summary",
+			cert:              &deniedCert,
+			expectSubresource: "stats",
+			expectForbidden:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reviewer := &fakeReviewServer{allowed: map[string]bool{"cert-user": true}}
+			url := startKubeletServer(t, newTestConfig(t, ca, false), reviewer)
+
+			code, body := get(t, url, tc.path, tc.cert, "")
+			if got := reviewer.reviewedSubresources(); len(got) != 1 || got[0] != tc.expectSubresource {
+				t.Errorf("expected a subject access review for subresource %q, got %q", tc.expectSubresource, got)
+			}
+			if tc.expectForbidden {
+				if code != http.StatusForbidden {
+					t.Errorf("expected status %d, got %d: %s", http.StatusForbidden, code, body)
+				}
+				if expectBody := "subresource=" + tc.expectSubresource; !strings.Contains(body, expectBody) {
+					t.Errorf("expected body to contain %q, got %q", expectBody, body)
+				}
+			} else if code == http.StatusUnauthorized || code == http.StatusForbidden {
+				t.Errorf("expected the request to be authorized, got status %d: %s", code, body)
+			}
+		})
+	}
+}
+
+func TestBuildAuthCache(t *testing.T) {
+	ca := newTestCA(t)
+	reviewer := &fakeReviewServer{
+		tokens:  map[string]string{"alice-token": "alice", "bob-token": "bob"},
+		allowed: map[string]bool{"alice": true},
+	}
+	url := startKubeletServer(t, newTestConfig(t, ca, false), reviewer)
+
+	// Both the allowed and the denied decision are cached, so repeating a
+	// request within the TTLs must not send another review.
+	for i := 0; i < 3; i++ {
+		if code, body := get(t, url, "/pods", nil, "alice-token"); code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, code, body)
+		}
+		if code, body := get(t, url, "/pods", nil, "bob-token"); code != http.StatusForbidden {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusForbidden, code, body)
+		}
+	}
+	if got := atomic.LoadInt32(&reviewer.tokenReviews); got != 2 {
+		t.Errorf("expected 2 token reviews, got %d", got)
+	}
+	if got := atomic.LoadInt32(&reviewer.subjectAccessReviews); got != 2 {
+		t.Errorf("expected 2 subject access reviews, got %d", got)
+	}
+}
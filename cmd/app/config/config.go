@@ -1,10 +1,87 @@
 package config
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // Config is the main context object for the controller manager.
+// 通过kubelet的/configz接口对外暴露，token不能出现在其中
 type Config struct {
-	NodeName          string
-	ApiServerEndpoint string
-	Token             string
+	NodeName          string `json:"nodeName"`
+	ApiServerEndpoint string `json:"apiServerEndpoint"`
+	Token             string `json:"-"`
+	RootDirectory     string `json:"rootDirectory"`
+	// ConfigMapAndSecretChangeDetectionStrategy configMap和secret的获取方式：Get、Cache或Watch
+	ConfigMapAndSecretChangeDetectionStrategy string `json:"configMapAndSecretChangeDetectionStrategy"`
+	// ContainerLogMaxSize 单个容器日志文件的大小上限，比如10Mi
+	ContainerLogMaxSize string `json:"containerLogMaxSize"`
+	// ContainerLogMaxFiles 每个容器实例保留的日志文件数
+	ContainerLogMaxFiles int `json:"containerLogMaxFiles"`
+	// Address、Port kubelet HTTPS服务监听的地址和端口，Port同时作为node的KubeletEndpoint
+	Address string `json:"address"`
+	Port    int32  `json:"port"`
+	// TLSCertFile、TLSPrivateKeyFile HTTPS服务的证书，没有指定时在CertDirectory中生成自签名证书
+	TLSCertFile       string `json:"tlsCertFile"`
+	TLSPrivateKeyFile string `json:"tlsPrivateKeyFile"`
+	CertDirectory     string `json:"certDirectory"`
+	// Authentication、Authorization 访问kubelet HTTPS服务的请求的认证和鉴权
+	Authentication KubeletAuthentication `json:"authentication"`
+	Authorization  KubeletAuthorization  `json:"authorization"`
+}
+
+// KubeletAuthentication 认证方式：客户端证书、bearer token（通过TokenReview交给apiserver认证）和匿名访问
+type KubeletAuthentication struct {
+	X509      KubeletX509Authentication      `json:"x509"`
+	Webhook   KubeletWebhookAuthentication   `json:"webhook"`
+	Anonymous KubeletAnonymousAuthentication `json:"anonymous"`
+}
+
+// KubeletX509Authentication ClientCAFile 签发客户端证书的CA，为空时不使用客户端证书认证
+type KubeletX509Authentication struct {
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+// KubeletWebhookAuthentication Enabled 是否通过TokenReview认证bearer token，CacheTTL 认证结果的缓存时间
+type KubeletWebhookAuthentication struct {
+	Enabled  bool            `json:"enabled"`
+	CacheTTL metav1.Duration `json:"cacheTTL"`
+}
+
+// KubeletAnonymousAuthentication Enabled 没有被任何方式认证的请求是否作为system:anonymous用户继续鉴权
+type KubeletAnonymousAuthentication struct {
+	Enabled bool `json:"enabled"`
+}
+
+// KubeletAuthorizationMode 鉴权方式
+type KubeletAuthorizationMode string
+
+const (
+	// KubeletAuthorizationModeAlwaysAllow 通过认证的请求都允许访问
+	KubeletAuthorizationModeAlwaysAllow KubeletAuthorizationMode = "AlwaysAllow"
+	// KubeletAuthorizationModeWebhook 通过SubjectAccessReview交给apiserver鉴权
+	KubeletAuthorizationModeWebhook KubeletAuthorizationMode = "Webhook"
+	// KubeletAuthorizationModeABAC 使用本地策略文件中的ABAC规则鉴权，不依赖apiserver
+	KubeletAuthorizationModeABAC KubeletAuthorizationMode = "ABAC"
+)
+
+// KubeletAuthorization 鉴权方式及其配置
+type KubeletAuthorization struct {
+	Mode    KubeletAuthorizationMode    `json:"mode"`
+	Webhook KubeletWebhookAuthorization `json:"webhook"`
+	ABAC    KubeletABACAuthorization    `json:"abac"`
+}
+
+// KubeletWebhookAuthorization 允许和拒绝的鉴权结果分别缓存的时间
+type KubeletWebhookAuthorization struct {
+	CacheAuthorizedTTL   metav1.Duration `json:"cacheAuthorizedTTL"`
+	CacheUnauthorizedTTL metav1.Duration `json:"cacheUnauthorizedTTL"`
+}
+
+// KubeletABACAuthorization PolicyFile 每行一条规则的策略文件，修改后自动重新加载；
+// DecisionLogLevel 记录每次鉴权结果的日志级别
+type KubeletABACAuthorization struct {
+	PolicyFile       string `json:"policyFile"`
+	DecisionLogLevel int32  `json:"decisionLogLevel"`
 }
 
 // CompletedConfig same as Config, just to swap private object.
@@ -1,18 +1,30 @@
 package app
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/component-base/configz"
 	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/cmd/app/config"
 	"k8s.io/kubernetes/cmd/app/options"
 	"k8s.io/kubernetes/pkg/bootstrap"
 	client2 "k8s.io/kubernetes/pkg/client"
 	"k8s.io/kubernetes/pkg/common"
+	"k8s.io/kubernetes/pkg/kubelet/metrics"
+	"k8s.io/kubernetes/pkg/kubelet/server"
 	"k8s.io/kubernetes/pkg/mycore"
 	"k8s.io/kubernetes/pkg/node"
 	"k8s.io/kubernetes/pkg/node/lease"
+	"net"
 	"os"
+	"path/filepath"
 )
 
 // NewKubeletCommand 启动kubelet
@@ -44,7 +56,10 @@ func NewKubeletCommand() *cobra.Command {
 			}
 
 			// 4. 注册node节点
-			node.RegisterNode(cfg.NodeName, kubeClient)
+			node.RegisterNode(cfg.NodeName, cfg.Port, kubeClient)
+
+			// 注册监控指标，之后各组件记录的指标都可以通过/metrics接口获取
+			metrics.Register()
 
 			// 5. 启动租约控制器
 			// 更新node的状态信息，如果没有，就会改成notReady
@@ -52,7 +67,43 @@ func NewKubeletCommand() *cobra.Command {
 
 			// 6. 初始化kubelet
 			// 启动kubelet Start() 此方法会阻塞
-			k := mycore.NewSampleKubelet(client, cfg.NodeName)
+			containerLogMaxSize, err := resource.ParseQuantity(cfg.ContainerLogMaxSize)
+			if err != nil {
+				return fmt.Errorf("invalid container log max size %q: %v", cfg.ContainerLogMaxSize, err)
+			}
+			if cfg.ContainerLogMaxFiles < 2 {
+				return fmt.Errorf("invalid container log max files %d, must be >= 2", cfg.ContainerLogMaxFiles)
+			}
+			k, err := mycore.NewSampleKubelet(client, cfg.NodeName, cfg.RootDirectory,
+				mycore.ResourceChangeDetectionStrategy(cfg.ConfigMapAndSecretChangeDetectionStrategy),
+				containerLogMaxSize.Value(), cfg.ContainerLogMaxFiles)
+			if err != nil {
+				return err
+			}
+
+			// 7. 启动kubelet的HTTPS服务，对外提供/pods、/containerLogs等接口
+			tlsOptions, err := initializeTLS(cfg.Config)
+			if err != nil {
+				return err
+			}
+			cz, err := configz.New("kubeletconfig")
+			if err != nil {
+				return err
+			}
+			cz.Set(cfg.Config)
+			address := net.ParseIP(cfg.Address)
+			if address == nil {
+				return fmt.Errorf("invalid address %q", cfg.Address)
+			}
+			// 访问HTTPS服务的请求先认证再鉴权，bearer token和Webhook鉴权通过kubelet的客户端交给apiserver完成
+			// runAuthReload 监听客户端CA文件和ABAC策略文件，变化后重新加载
+			auth, runAuthReload, err := BuildAuth(types.NodeName(cfg.NodeName), kubeClient, *cfg.Config)
+			if err != nil {
+				return err
+			}
+			runAuthReload(wait.NeverStop)
+			go k.ListenAndServe(address, uint(cfg.Port), tlsOptions, auth)
+
 			k.Start()
 
 			return nil
@@ -79,3 +130,51 @@ func NewKubeletCommand() *cobra.Command {
 
 	return cmd
 }
+
+// initializeTLS 没有指定证书时，在证书目录中生成节点名对应的自签名证书，已经生成过的证书会被复用。
+// 指定了client-ca-file时，客户端证书用这个CA校验
+func initializeTLS(cfg *config.Config) (*server.TLSOptions, error) {
+	certFile, keyFile := cfg.TLSCertFile, cfg.TLSPrivateKeyFile
+	if certFile == "" && keyFile == "" {
+		certFile = filepath.Join(cfg.CertDirectory, "kubelet.crt")
+		keyFile = filepath.Join(cfg.CertDirectory, "kubelet.key")
+
+		canReadCertAndKey, err := cert.CanReadCertAndKey(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if !canReadCertAndKey {
+			certData, keyData, err := cert.GenerateSelfSignedCertKey(cfg.NodeName, nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("unable to generate self signed cert: %w", err)
+			}
+			if err := cert.WriteCert(certFile, certData); err != nil {
+				return nil, err
+			}
+			if err := keyutil.WriteKey(keyFile, keyData); err != nil {
+				return nil, err
+			}
+			klog.V(4).InfoS("Using self-signed cert", "TLSCertFile", certFile, "TLSPrivateKeyFile", keyFile)
+		}
+	}
+
+	tlsOptions := &server.TLSOptions{
+		Config: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+
+	if len(cfg.Authentication.X509.ClientCAFile) > 0 {
+		clientCAs, err := cert.NewPool(cfg.Authentication.X509.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client CA file %s: %w", cfg.Authentication.X509.ClientCAFile, err)
+		}
+		// 要求客户端提供证书，但不拒绝没有证书或证书校验不通过的连接，这些请求还可以通过token或匿名访问
+		tlsOptions.Config.ClientCAs = clientCAs
+		tlsOptions.Config.ClientAuth = tls.RequestClientCert
+	}
+
+	return tlsOptions, nil
+}
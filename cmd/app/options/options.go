@@ -4,16 +4,43 @@ import (
 	"flag"
 	"fmt"
 	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/cmd/app/config"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 type SampleKubeletOptions struct {
 	NodeName          string
 	ApiServerEndpoint string
 	Token             string
+	// RootDirectory kubelet的根目录，pod的目录和卷都在这个目录下
+	RootDirectory string
+	// ConfigMapAndSecretChangeDetectionStrategy configMap和secret卷内容的更新方式
+	ConfigMapAndSecretChangeDetectionStrategy string
+	// ContainerLogMaxSize、ContainerLogMaxFiles 容器日志的轮转
+	ContainerLogMaxSize  string
+	ContainerLogMaxFiles int
+	// Address、Port kubelet HTTPS服务监听的地址和端口
+	Address string
+	Port    int32
+	// TLSCertFile、TLSPrivateKeyFile、CertDirectory HTTPS服务的证书
+	TLSCertFile       string
+	TLSPrivateKeyFile string
+	CertDirectory     string
+	// 访问kubelet HTTPS服务的认证和鉴权
+	ClientCAFile                             string
+	AuthenticationTokenWebhook               bool
+	AuthenticationTokenWebhookCacheTTL       time.Duration
+	AnonymousAuth                            bool
+	AuthorizationMode                        string
+	AuthorizationWebhookCacheAuthorizedTTL   time.Duration
+	AuthorizationWebhookCacheUnauthorizedTTL time.Duration
+	AuthorizationPolicyFile                  string
+	AuthorizationABACDecisionLogLevel        int32
 }
 
 // NewKubeControllerManagerOptions creates a new KubeControllerManagerOptions with a default config.
@@ -27,6 +54,43 @@ func (s SampleKubeletOptions) Config() *config.Config {
 		NodeName:          s.NodeName,
 		ApiServerEndpoint: fmt.Sprintf("https://%s", s.ApiServerEndpoint),
 		Token:             s.Token,
+		RootDirectory:     s.RootDirectory,
+
+		ConfigMapAndSecretChangeDetectionStrategy: s.ConfigMapAndSecretChangeDetectionStrategy,
+		ContainerLogMaxSize:                       s.ContainerLogMaxSize,
+		ContainerLogMaxFiles:                      s.ContainerLogMaxFiles,
+		Address:                                   s.Address,
+		Port:                                      s.Port,
+		TLSCertFile:                               s.TLSCertFile,
+		TLSPrivateKeyFile:                         s.TLSPrivateKeyFile,
+		CertDirectory:                             s.CertDirectory,
+		Authentication: config.KubeletAuthentication{
+			X509: config.KubeletX509Authentication{
+				ClientCAFile: s.ClientCAFile,
+			},
+			Webhook: config.KubeletWebhookAuthentication{
+				Enabled:  s.AuthenticationTokenWebhook,
+				CacheTTL: metav1.Duration{Duration: s.AuthenticationTokenWebhookCacheTTL},
+			},
+			Anonymous: config.KubeletAnonymousAuthentication{
+				Enabled: s.AnonymousAuth,
+			},
+		},
+		Authorization: config.KubeletAuthorization{
+			Mode: config.KubeletAuthorizationMode(s.AuthorizationMode),
+			Webhook: config.KubeletWebhookAuthorization{
+				CacheAuthorizedTTL:   metav1.Duration{Duration: s.AuthorizationWebhookCacheAuthorizedTTL},
+				CacheUnauthorizedTTL: metav1.Duration{Duration: s.AuthorizationWebhookCacheUnauthorizedTTL},
+			},
+			ABAC: config.KubeletABACAuthorization{
+				PolicyFile:       s.AuthorizationPolicyFile,
+				DecisionLogLevel: s.AuthorizationABACDecisionLogLevel,
+			},
+		},
+	}
+	// 没有指定证书目录时使用根目录下的pki目录
+	if c.CertDirectory == "" {
+		c.CertDirectory = filepath.Join(s.RootDirectory, "pki")
 	}
 	return c
 }
@@ -34,6 +98,19 @@ func (s SampleKubeletOptions) Config() *config.Config {
 const (
 	DefaultNodeName          = "my-sample-kubelet"
 	DefaultApiServerEndpoint = "127.0.0.1:6443"
+	DefaultRootDirectory     = "/var/lib/my-sample-kubelet"
+
+	DefaultConfigMapAndSecretChangeDetectionStrategy = "Watch"
+	DefaultContainerLogMaxSize                       = "10Mi"
+	DefaultContainerLogMaxFiles                      = 5
+	DefaultAddress                                   = "0.0.0.0"
+	DefaultPort                                      = 10250
+
+	DefaultAuthenticationTokenWebhookCacheTTL       = 2 * time.Minute
+	DefaultAuthorizationMode                        = string(config.KubeletAuthorizationModeWebhook)
+	DefaultAuthorizationWebhookCacheAuthorizedTTL   = 5 * time.Minute
+	DefaultAuthorizationWebhookCacheUnauthorizedTTL = 30 * time.Second
+	DefaultAuthorizationABACDecisionLogLevel        = 4
 )
 
 // AddFlags 加入命令行参数
@@ -41,6 +118,37 @@ func (s *SampleKubeletOptions) AddFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&s.NodeName, "nodeName", DefaultNodeName, "kubelet name")
 	flags.StringVar(&s.ApiServerEndpoint, "apiserver-endpoint", DefaultApiServerEndpoint, "api-server-endpoint")
 	flags.StringVar(&s.Token, "token", "", "kubeadm token")
+	flags.StringVar(&s.RootDirectory, "root-dir", DefaultRootDirectory, "Directory path for managing kubelet files (volume mounts, etc).")
+	flags.StringVar(&s.ConfigMapAndSecretChangeDetectionStrategy, "configmap-and-secret-change-detection-strategy",
+		DefaultConfigMapAndSecretChangeDetectionStrategy, "A mode in which ConfigMap and Secret managers are running. Valid values include: Get, Cache, Watch.")
+	flags.StringVar(&s.ContainerLogMaxSize, "container-log-max-size", DefaultContainerLogMaxSize,
+		"Set the maximum size (e.g. 10Mi) of container log file before it is rotated.")
+	flags.IntVar(&s.ContainerLogMaxFiles, "container-log-max-files", DefaultContainerLogMaxFiles,
+		"Set the maximum number of container log files that can be present for a container. The number must be >= 2.")
+	flags.StringVar(&s.Address, "address", DefaultAddress, "The IP address for the Kubelet to serve on (set to '0.0.0.0' or '::' for listening in all interfaces and IP families)")
+	flags.Int32Var(&s.Port, "port", DefaultPort, "The port for the Kubelet to serve on.")
+	flags.StringVar(&s.TLSCertFile, "tls-cert-file", "", "File containing x509 Certificate used for serving HTTPS (with intermediate certs, if any, concatenated after server cert). "+
+		"If --tls-cert-file and --tls-private-key-file are not provided, a self-signed certificate and key are generated for the public address and saved to the directory passed to --cert-dir.")
+	flags.StringVar(&s.TLSPrivateKeyFile, "tls-private-key-file", "", "File containing x509 private key matching --tls-cert-file.")
+	flags.StringVar(&s.CertDirectory, "cert-dir", "", "The directory where the TLS certs are located. "+
+		"If --tls-cert-file and --tls-private-key-file are provided, this flag will be ignored. (default <root-dir>/pki)")
+	flags.StringVar(&s.ClientCAFile, "client-ca-file", "", "If set, any request presenting a client certificate signed by one of the authorities in the client-ca-file "+
+		"is authenticated with an identity corresponding to the CommonName of the client certificate.")
+	flags.BoolVar(&s.AuthenticationTokenWebhook, "authentication-token-webhook", true, "Use the TokenReview API to determine authentication for bearer tokens.")
+	flags.DurationVar(&s.AuthenticationTokenWebhookCacheTTL, "authentication-token-webhook-cache-ttl", DefaultAuthenticationTokenWebhookCacheTTL,
+		"The duration to cache responses from the webhook token authenticator.")
+	flags.BoolVar(&s.AnonymousAuth, "anonymous-auth", false, "Enables anonymous requests to the Kubelet server. Requests that are not rejected by another "+
+		"authentication method are treated as anonymous requests. Anonymous requests have a username of system:anonymous, and a group name of system:unauthenticated.")
+	flags.StringVar(&s.AuthorizationMode, "authorization-mode", DefaultAuthorizationMode, "Authorization mode for Kubelet server. Valid options are AlwaysAllow, Webhook or ABAC. "+
+		"Webhook mode uses the SubjectAccessReview API to determine authorization. ABAC mode uses the policies in --authorization-policy-file.")
+	flags.DurationVar(&s.AuthorizationWebhookCacheAuthorizedTTL, "authorization-webhook-cache-authorized-ttl", DefaultAuthorizationWebhookCacheAuthorizedTTL,
+		"The duration to cache 'authorized' responses from the webhook authorizer.")
+	flags.DurationVar(&s.AuthorizationWebhookCacheUnauthorizedTTL, "authorization-webhook-cache-unauthorized-ttl", DefaultAuthorizationWebhookCacheUnauthorizedTTL,
+		"The duration to cache 'unauthorized' responses from the webhook authorizer.")
+	flags.StringVar(&s.AuthorizationPolicyFile, "authorization-policy-file", "", "File with authorization policy in json line by line format, used with --authorization-mode=ABAC. "+
+		"The file is reloaded when it changes.")
+	flags.Int32Var(&s.AuthorizationABACDecisionLogLevel, "authorization-abac-decision-log-level", DefaultAuthorizationABACDecisionLogLevel,
+		"The log verbosity at which every ABAC authorization decision is logged.")
 
 	s.addKlogFlags(flags)
 }
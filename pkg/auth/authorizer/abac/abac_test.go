@@ -0,0 +1,118 @@
+package abac
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+const testNodeName = "test-node"
+
+// writePolicyFile writes one policy per line to path.
+func writePolicyFile(t *testing.T, path string, policies ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(policies, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nodePolicy allows user read-only access to resource, which may name a
+// subresource as <resource>/<subresource>.
+func nodePolicy(user, resource string) string {
+	return `{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "` + user +
+		`", "namespace": "*", "resource": "` + resource + `", "apiGroup": "*", "readonly": true}}`
+}
+
+// nodeRequest returns the attributes of a kubelet API GET request the way
+// the kubelet builds them.
+func nodeRequest(username, subresource string) authorizer.Attributes {
+	return authorizer.AttributesRecord{
+		User:            &user.DefaultInfo{Name: username},
+		Verb:            "get",
+		APIVersion:      "v1",
+		Resource:        "nodes",
+		Subresource:     subresource,
+		Name:            testNodeName,
+		ResourceRequest: true,
+	}
+}
+
+func TestNodeSubresourcePolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.jsonl")
+	writePolicyFile(t, path,
+		"# comments and blank lines are skipped",
+		"",
+		nodePolicy("stats-reader", "nodes/stats"),
+		nodePolicy("log-reader", "nodes/log"),
+		nodePolicy("proxy-user", "nodes/proxy"),
+		nodePolicy("node-admin", "nodes"),
+	)
+	policies, err := NewFromFile(path)
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	if len(policies) != 4 {
+		t.Fatalf("expected 4 policies, got %d", len(policies))
+	}
+
+	tests := []struct {
+		user        string
+		subresource string
+		allowed     bool
+	}{
+		{user: "stats-reader", subresource: "stats", allowed: true},
+		{user: "stats-reader", subresource: "log", allowed: false},
+		{user: "stats-reader", subresource: "proxy", allowed: false},
+		{user: "log-reader", subresource: "log", allowed: true},
+		{user: "log-reader", subresource: "stats", allowed: false},
+		{user: "log-reader", subresource: "proxy", allowed: false},
+		{user: "proxy-user", subresource: "proxy", allowed: true},
+		{user: "proxy-user", subresource: "stats", allowed: false},
+		{user: "proxy-user", subresource: "log", allowed: false},
+		{user: "node-admin", subresource: "proxy", allowed: true},
+		{user: "node-admin", subresource: "stats", allowed: true},
+		{user: "node-admin", subresource: "log", allowed: true},
+		{user: "stranger", subresource: "proxy", allowed: false},
+	}
+	for _, tc := range tests {
+		decision, _, err := policies.Authorize(context.Background(), nodeRequest(tc.user, tc.subresource))
+		if err != nil {
+			t.Fatalf("%s on nodes/%s: unexpected error: %v", tc.user, tc.subresource, err)
+		}
+		if allowed := decision == authorizer.DecisionAllow; allowed != tc.allowed {
+			t.Errorf("%s on nodes/%s: expected allowed=%v, got %v", tc.user, tc.subresource, tc.allowed, allowed)
+		}
+	}
+}
+
+func TestReadonlyPolicyDeniesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.jsonl")
+	writePolicyFile(t, path, nodePolicy("proxy-user", "nodes/proxy"))
+	policies, err := NewFromFile(path)
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	attrs := nodeRequest("proxy-user", "proxy").(authorizer.AttributesRecord)
+	attrs.Verb = "create"
+	if decision, _, _ := policies.Authorize(context.Background(), attrs); decision == authorizer.DecisionAllow {
+		t.Errorf("expected a read-only policy to deny create on nodes/proxy")
+	}
+}
+
+func TestNewFromFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.jsonl")
+	writePolicyFile(t, path, nodePolicy("stats-reader", "nodes/stats"), `{"apiVersion": `)
+	_, err := NewFromFile(path)
+	if err == nil {
+		t.Fatal("expected an error loading an invalid policy file")
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected the error to name the invalid line, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package abac
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/klog/v2"
+)
+
+// PolicyFileAuthorizer 使用策略文件中的ABAC规则鉴权，文件修改后自动重新加载。
+// 重新加载失败时继续使用上一次成功加载的规则
+type PolicyFileAuthorizer struct {
+	path string
+	// decisionLogLevel 记录鉴权结果的日志级别
+	decisionLogLevel klog.Level
+
+	lock     sync.RWMutex
+	policies PolicyList
+}
+
+var _ authorizer.Authorizer = &PolicyFileAuthorizer{}
+
+// NewPolicyFileAuthorizer 加载策略文件，文件不存在或者格式错误时返回错误
+func NewPolicyFileAuthorizer(path string, decisionLogLevel klog.Level) (*PolicyFileAuthorizer, error) {
+	policies, err := NewFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	klog.InfoS("Loaded ABAC policy file", "path", path, "policies", len(policies))
+	return &PolicyFileAuthorizer{
+		path:             path,
+		decisionLogLevel: decisionLogLevel,
+		policies:         policies,
+	}, nil
+}
+
+// Authorize 按顺序匹配规则，有一条匹配即允许
+func (a *PolicyFileAuthorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	a.lock.RLock()
+	policies := a.policies
+	a.lock.RUnlock()
+
+	decision, reason, err := policies.Authorize(ctx, attrs)
+	klog.V(a.decisionLogLevel).InfoS("ABAC authorization decision", "user", attrs.GetUser().GetName(), "groups", attrs.GetUser().GetGroups(),
+		"verb", attrs.GetVerb(), "resource", attrs.GetResource(), "subresource", attrs.GetSubresource(), "path", attrs.GetPath(), "allowed", decision == authorizer.DecisionAllow)
+	return decision, reason, err
+}
+
+// Run 监听策略文件的变化并重新加载，直到stopCh关闭。
+// 监听的是文件所在的目录，编辑器保存或者ConfigMap更新时文件被替换，监听文件本身会丢失之后的事件
+func (a *PolicyFileAuthorizer) Run(stopCh <-chan struct{}) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.ErrorS(err, "Unable to create inotify, ABAC policy file will not be reloaded", "path", a.path)
+		return
+	}
+	defer w.Close()
+
+	dir := filepath.Dir(a.path)
+	if err := w.Add(dir); err != nil {
+		klog.ErrorS(err, "Unable to watch directory of ABAC policy file, it will not be reloaded", "path", a.path)
+		return
+	}
+	// 创建authorizer到开始监听之间文件的变化没有事件，监听之后重新加载一次
+	if err := a.reload(); err != nil {
+		klog.ErrorS(err, "Failed to reload ABAC policy file, keeping previous policies", "path", a.path)
+	}
+
+	for {
+		select {
+		case event := <-w.Events:
+			if !a.affectsPolicyFile(event) {
+				continue
+			}
+			if err := a.reload(); err != nil {
+				klog.ErrorS(err, "Failed to reload ABAC policy file, keeping previous policies", "path", a.path)
+			}
+		case err := <-w.Errors:
+			klog.ErrorS(err, "Error while watching ABAC policy file", "path", a.path)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// affectsPolicyFile 事件是否可能改变了策略文件的内容。
+// ConfigMap挂载的文件是指向..data目录的符号链接，更新时替换的是..data，所以..开头的文件的变化也要重新加载
+func (a *PolicyFileAuthorizer) affectsPolicyFile(event fsnotify.Event) bool {
+	// 新文件被rename到这个路径上时收到的是Create事件
+	if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return false
+	}
+	return filepath.Clean(event.Name) == filepath.Clean(a.path) || strings.HasPrefix(filepath.Base(event.Name), "..")
+}
+
+func (a *PolicyFileAuthorizer) reload() error {
+	policies, err := NewFromFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to load policy file: %v", err)
+	}
+	a.lock.Lock()
+	a.policies = policies
+	a.lock.Unlock()
+	klog.InfoS("Reloaded ABAC policy file", "path", a.path, "policies", len(policies))
+	return nil
+}
@@ -0,0 +1,163 @@
+package abac
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+const (
+	testWaitTimeout  = 10 * time.Second
+	testPollInterval = 20 * time.Millisecond
+)
+
+// allowed reports whether a allows user to get the node subresource.
+func allowed(t *testing.T, a authorizer.Authorizer, user, subresource string) bool {
+	t.Helper()
+	decision, _, err := a.Authorize(context.Background(), nodeRequest(user, subresource))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return decision == authorizer.DecisionAllow
+}
+
+// waitForDecision polls until a allows or denies user the node subresource as expected.
+func waitForDecision(t *testing.T, a authorizer.Authorizer, user, subresource string, expectAllowed bool) {
+	t.Helper()
+	deadline := time.Now().Add(testWaitTimeout)
+	for allowed(t, a, user, subresource) != expectAllowed {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s on nodes/%s to be allowed=%v", user, subresource, expectAllowed)
+		}
+		time.Sleep(testPollInterval)
+	}
+}
+
+// replacePolicyFile atomically replaces the file at path with one policy per
+// line, the way editors and ConfigMap updates do.
+func replacePolicyFile(t *testing.T, path string, policies ...string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	writePolicyFile(t, tmp, policies...)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startPolicyFileAuthorizer loads the policy file at path and watches it
+// until the test ends.
+func startPolicyFileAuthorizer(t *testing.T, path string) *PolicyFileAuthorizer {
+	t.Helper()
+	a, err := NewPolicyFileAuthorizer(path, 4)
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(stopCh)
+	}()
+	t.Cleanup(func() {
+		close(stopCh)
+		<-done
+	})
+	return a
+}
+
+func TestPolicyFileAuthorizerReload(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(t *testing.T, path string, policies ...string)
+	}{
+		{
+			name:  "rewrite in place",
+			write: writePolicyFile,
+		},
+		{
+			name:  "atomic rename",
+			write: replacePolicyFile,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.jsonl")
+			writePolicyFile(t, path, nodePolicy("alice", "nodes/stats"))
+			a := startPolicyFileAuthorizer(t, path)
+
+			if !allowed(t, a, "alice", "stats") {
+				t.Fatal("expected alice to be allowed nodes/stats")
+			}
+			if allowed(t, a, "alice", "log") {
+				t.Fatal("expected alice to be denied nodes/log")
+			}
+
+			tc.write(t, path, nodePolicy("alice", "nodes/log"))
+			waitForDecision(t, a, "alice", "log", true)
+			waitForDecision(t, a, "alice", "stats", false)
+
+			// a second change must be picked up as well, the watch survives the
+			// file being replaced
+			tc.write(t, path, nodePolicy("alice", "nodes/proxy"))
+			waitForDecision(t, a, "alice", "proxy", true)
+			waitForDecision(t, a, "alice", "log", false)
+		})
+	}
+}
+
+func TestPolicyFileAuthorizerKeepsLastGoodPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.jsonl")
+	writePolicyFile(t, path, nodePolicy("alice", "nodes/stats"))
+	a, err := NewPolicyFileAuthorizer(path, 4)
+	if err != nil {
+		t.Fatalf("failed to load policy file: %v", err)
+	}
+
+	writePolicyFile(t, path, nodePolicy("alice", "nodes/log"), `{"apiVersion": `)
+	if err := a.reload(); err == nil {
+		t.Error("expected an error reloading an invalid policy file")
+	}
+	if !allowed(t, a, "alice", "stats") || allowed(t, a, "alice", "log") {
+		t.Error("expected the last good policy to be kept after an invalid policy file")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.reload(); err == nil {
+		t.Error("expected an error reloading a missing policy file")
+	}
+	if !allowed(t, a, "alice", "stats") {
+		t.Error("expected the last good policy to be kept after the policy file was removed")
+	}
+
+	// the watcher keeps the last good policy too, and recovers once the file
+	// is valid again. The file is replaced rather than rewritten, a rewrite
+	// briefly leaves an empty file, which is a valid policy that denies all.
+	writePolicyFile(t, path, nodePolicy("alice", "nodes/stats"))
+	a = startPolicyFileAuthorizer(t, path)
+	replacePolicyFile(t, path, nodePolicy("alice", "nodes/log"), `{"apiVersion": `)
+	time.Sleep(10 * testPollInterval)
+	if !allowed(t, a, "alice", "stats") || allowed(t, a, "alice", "log") {
+		t.Error("expected the watcher to keep the last good policy after an invalid policy file")
+	}
+	replacePolicyFile(t, path, nodePolicy("alice", "nodes/log"))
+	waitForDecision(t, a, "alice", "log", true)
+	waitForDecision(t, a, "alice", "stats", false)
+}
+
+func TestNewPolicyFileAuthorizerInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewPolicyFileAuthorizer(filepath.Join(dir, "missing.jsonl"), 4); err == nil {
+		t.Error("expected an error for a missing policy file")
+	}
+	path := filepath.Join(dir, "policy.jsonl")
+	writePolicyFile(t, path, `{"apiVersion": `)
+	if _, err := NewPolicyFileAuthorizer(path, 4); err == nil {
+		t.Error("expected an error for an invalid policy file")
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// This const block defines the metric names for the kubelet metrics.
+const (
+	KubeletSubsystem              = "kubelet"
+	PodWorkerDurationKey          = "pod_worker_duration_seconds"
+	PodWorkerSyncErrorsKey        = "pod_worker_sync_errors_total"
+	PodStartDurationKey           = "pod_start_duration_seconds"
+	PodStatusPatchDurationKey     = "pod_status_patch_duration_seconds"
+	PodStatusPatchErrorsKey       = "pod_status_patch_errors_total"
+	RunningPodsKey                = "running_pods"
+	RunningContainersKey          = "running_containers"
+	ManagedEphemeralContainersKey = "managed_ephemeral_containers"
+	NodeLeaseRenewErrorsKey       = "node_lease_renew_errors_total"
+
+	// Metrics keys for the prober
+	ProberSubsystem = "prober"
+	ProbeTotalKey   = "probe_total"
+)
+
+var (
+	// PodWorkerDuration is a Histogram that tracks the duration (in seconds) in takes to sync a single pod.
+	// Broken down by the operation type.
+	PodWorkerDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           PodWorkerDurationKey,
+			Help:           "Duration in seconds to sync a single pod. Broken down by operation type: create, update, sync or kill",
+			Buckets:        metrics.DefBuckets,
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation_type"},
+	)
+	// PodWorkerSyncErrors is a Counter that tracks the number of failed pod syncs.
+	// Broken down by the operation type.
+	PodWorkerSyncErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           PodWorkerSyncErrorsKey,
+			Help:           "Cumulative number of errors syncing a single pod. Broken down by operation type: create, update, sync or kill",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation_type"},
+	)
+	// PodStartDuration is a Histogram that tracks the duration (in seconds) it takes for a single pod to run
+	// since it's first time seen by kubelet.
+	PodStartDuration = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           PodStartDurationKey,
+			Help:           "Duration in seconds from kubelet seeing a pod for the first time to the pod starting to run",
+			Buckets:        []float64{0.5, 1, 2, 3, 4, 5, 6, 8, 10, 20, 30, 45, 60, 120, 180, 240, 300, 360, 480, 600, 900, 1200, 1800, 2700, 3600},
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// PodStatusPatchDuration is a Histogram that tracks the duration (in seconds) it takes to patch a pod status
+	// to the API server.
+	PodStatusPatchDuration = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           PodStatusPatchDurationKey,
+			Help:           "Duration in seconds to patch a pod status to the API server, including failed attempts",
+			Buckets:        metrics.DefBuckets,
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// PodStatusPatchErrors is a Counter that tracks the number of failed pod status patches.
+	PodStatusPatchErrors = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           PodStatusPatchErrorsKey,
+			Help:           "Cumulative number of errors patching a pod status to the API server",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// RunningPodCount is a gauge that tracks the number of pods with a running pod sandbox.
+	RunningPodCount = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           RunningPodsKey,
+			Help:           "Number of pods that have a running pod sandbox",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// RunningContainerCount is a gauge that tracks the number of containers known to the runtime.
+	// Broken down by the container state.
+	RunningContainerCount = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           RunningContainersKey,
+			Help:           "Number of containers currently running",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"container_state"},
+	)
+	// ManagedEphemeralContainers is a gauge that indicates how many ephemeral containers are managed by this kubelet.
+	ManagedEphemeralContainers = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           ManagedEphemeralContainersKey,
+			Help:           "Current number of ephemeral containers in pods managed by this kubelet.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// NodeLeaseRenewErrors is a Counter that tracks the number of failed node lease renewals.
+	NodeLeaseRenewErrors = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      KubeletSubsystem,
+			Name:           NodeLeaseRenewErrorsKey,
+			Help:           "Cumulative number of errors renewing the node lease",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	// ProberResults stores the cumulative number of a probe by result as prometheus metrics.
+	ProberResults = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      ProberSubsystem,
+			Name:           ProbeTotalKey,
+			Help:           "Cumulative number of a liveness, readiness or startup probe for a container by result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"probe_type",
+			"result",
+			"container",
+			"pod",
+			"namespace",
+			"pod_uid"},
+	)
+)
+
+var registerMetrics sync.Once
+
+// Register registers all metrics.
+func Register() {
+	// Register the metrics.
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(PodWorkerDuration)
+		legacyregistry.MustRegister(PodWorkerSyncErrors)
+		legacyregistry.MustRegister(PodStartDuration)
+		legacyregistry.MustRegister(PodStatusPatchDuration)
+		legacyregistry.MustRegister(PodStatusPatchErrors)
+		legacyregistry.MustRegister(RunningPodCount)
+		legacyregistry.MustRegister(RunningContainerCount)
+		legacyregistry.MustRegister(ManagedEphemeralContainers)
+		legacyregistry.MustRegister(NodeLeaseRenewErrors)
+		legacyregistry.MustRegister(ProberResults)
+	})
+}
+
+// SinceInSeconds gets the time since the specified start in seconds.
+func SinceInSeconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
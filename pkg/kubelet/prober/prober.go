@@ -23,16 +23,19 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/record"
 	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/util/format"
 
 	"k8s.io/kubernetes/pkg/kubelet/prober/results"
 	"k8s.io/kubernetes/pkg/probe"
 	execprobe "k8s.io/kubernetes/pkg/probe/exec"
+	grpcprobe "k8s.io/kubernetes/pkg/probe/grpc"
 	httpprobe "k8s.io/kubernetes/pkg/probe/http"
 	tcpprobe "k8s.io/kubernetes/pkg/probe/tcp"
 	"k8s.io/utils/exec"
@@ -52,6 +55,7 @@ type prober struct {
 	livenessHTTP  httpprobe.Prober
 	startupHTTP   httpprobe.Prober
 	tcp           tcpprobe.Prober
+	grpc          grpcprobe.Prober
 	runner        kubecontainer.CommandRunner
 
 	recorder record.EventRecorder
@@ -70,6 +74,7 @@ func newProber(
 		livenessHTTP:  httpprobe.New(followNonLocalRedirects),
 		startupHTTP:   httpprobe.New(followNonLocalRedirects),
 		tcp:           tcpprobe.New(),
+		grpc:          grpcprobe.New(),
 		runner:        runner,
 		recorder:      recorder,
 	}
@@ -147,9 +152,60 @@ func buildHeader(headerList []v1.HTTPHeader) http.Header {
 	return headers
 }
 
-// 目前不支持
 func (pb *prober) runProbe(probeType probeType, p *v1.Probe, pod *v1.Pod, status v1.PodStatus, container v1.Container, containerID kubecontainer.ContainerID) (probe.Result, string, error) {
-	return probe.Result(""), "", nil
+	timeout := time.Duration(p.TimeoutSeconds) * time.Second
+	if p.Exec != nil {
+		klog.V(4).InfoS("Exec-Probe runProbe", "pod", klog.KObj(pod), "containerName", container.Name, "execCommand", p.Exec.Command)
+		command := kubecontainer.ExpandContainerCommandOnlyStatic(p.Exec.Command, container.Env)
+		return pb.exec.Probe(pb.newExecInContainer(container, containerID, command, timeout))
+	}
+	if p.HTTPGet != nil {
+		scheme := strings.ToLower(string(p.HTTPGet.Scheme))
+		host := p.HTTPGet.Host
+		if host == "" {
+			host = status.PodIP
+		}
+		port, err := extractPort(p.HTTPGet.Port, container)
+		if err != nil {
+			return probe.Unknown, "", err
+		}
+		path := p.HTTPGet.Path
+		klog.V(4).InfoS("HTTP-Probe Host", "scheme", scheme, "host", host, "port", port, "path", path)
+		url := formatURL(scheme, host, port, path)
+		headers := buildHeader(p.HTTPGet.HTTPHeaders)
+		klog.V(4).InfoS("HTTP-Probe Headers", "headers", headers)
+		switch probeType {
+		case liveness:
+			return pb.livenessHTTP.Probe(url, headers, timeout)
+		case startup:
+			return pb.startupHTTP.Probe(url, headers, timeout)
+		default:
+			return pb.readinessHTTP.Probe(url, headers, timeout)
+		}
+	}
+	if p.TCPSocket != nil {
+		port, err := extractPort(p.TCPSocket.Port, container)
+		if err != nil {
+			return probe.Unknown, "", err
+		}
+		host := p.TCPSocket.Host
+		if host == "" {
+			host = status.PodIP
+		}
+		klog.V(4).InfoS("TCP-Probe Host", "host", host, "port", port, "timeout", timeout)
+		return pb.tcp.Probe(host, port, timeout)
+	}
+	if p.GRPC != nil {
+		host := status.PodIP
+		service := ""
+		if p.GRPC.Service != nil {
+			service = *p.GRPC.Service
+		}
+		klog.V(4).InfoS("GRPC-Probe", "host", host, "service", service, "port", p.GRPC.Port, "timeout", timeout)
+		return pb.grpc.Probe(host, service, int(p.GRPC.Port), timeout)
+	}
+	klog.InfoS("Failed to find probe builder for container", "containerName", container.Name)
+	return probe.Unknown, "", fmt.Errorf("missing probe handler for %s:%s", format.Pod(pod), container.Name)
 }
 
 func extractPort(param intstr.IntOrString, container v1.Container) (int, error) {
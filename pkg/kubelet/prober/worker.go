@@ -22,10 +22,12 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/component-base/metrics"
 
 	"k8s.io/klog/v2"
 	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
 	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	kubeletmetrics "k8s.io/kubernetes/pkg/kubelet/metrics"
 	"k8s.io/kubernetes/pkg/kubelet/prober/results"
 )
 
@@ -68,6 +70,12 @@ type worker struct {
 
 	// If set, skip probing.
 	onHold bool
+
+	// proberResultsMetricLabels holds the labels attached to this worker
+	// for the ProberResults metric by result.
+	proberResultsSuccessfulMetricLabels metrics.Labels
+	proberResultsFailedMetricLabels     metrics.Labels
+	proberResultsUnknownMetricLabels    metrics.Labels
 }
 
 // Creates and starts a new probe worker.
@@ -101,6 +109,23 @@ func newWorker(
 		w.initialValue = results.Unknown
 	}
 
+	basicMetricLabels := metrics.Labels{
+		"probe_type": w.probeType.String(),
+		"container":  w.container.Name,
+		"pod":        w.pod.Name,
+		"namespace":  w.pod.Namespace,
+		"pod_uid":    string(w.pod.UID),
+	}
+
+	w.proberResultsSuccessfulMetricLabels = deepCopyPrometheusLabels(basicMetricLabels)
+	w.proberResultsSuccessfulMetricLabels["result"] = probeResultSuccessful
+
+	w.proberResultsFailedMetricLabels = deepCopyPrometheusLabels(basicMetricLabels)
+	w.proberResultsFailedMetricLabels["result"] = probeResultFailed
+
+	w.proberResultsUnknownMetricLabels = deepCopyPrometheusLabels(basicMetricLabels)
+	w.proberResultsUnknownMetricLabels["result"] = probeResultUnknown
+
 	return w
 }
 
@@ -125,7 +150,9 @@ func (w *worker) run() {
 		}
 
 		w.probeManager.removeWorker(w.pod.UID, w.container.Name, w.probeType)
-
+		kubeletmetrics.ProberResults.Delete(w.proberResultsSuccessfulMetricLabels)
+		kubeletmetrics.ProberResults.Delete(w.proberResultsFailedMetricLabels)
+		kubeletmetrics.ProberResults.Delete(w.proberResultsUnknownMetricLabels)
 	}()
 
 probeLoop:
@@ -245,6 +272,15 @@ func (w *worker) doProbe() (keepGoing bool) {
 		return true
 	}
 
+	switch result {
+	case results.Success:
+		kubeletmetrics.ProberResults.With(w.proberResultsSuccessfulMetricLabels).Inc()
+	case results.Failure:
+		kubeletmetrics.ProberResults.With(w.proberResultsFailedMetricLabels).Inc()
+	default:
+		kubeletmetrics.ProberResults.With(w.proberResultsUnknownMetricLabels).Inc()
+	}
+
 	if w.lastResult == result {
 		w.resultRun++
 	} else {
@@ -271,3 +307,11 @@ func (w *worker) doProbe() (keepGoing bool) {
 
 	return true
 }
+
+func deepCopyPrometheusLabels(m metrics.Labels) metrics.Labels {
+	ret := make(metrics.Labels, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
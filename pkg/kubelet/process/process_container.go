@@ -0,0 +1,563 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/kubelet/config"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/events"
+)
+
+const (
+	// startErrorExitCode 进程无法启动时记录的退出码，与containerd的行为保持一致
+	startErrorExitCode = 128
+
+	// 容器退出的原因
+	reasonCompleted  = "Completed"
+	reasonError      = "Error"
+	reasonStartError = "StartError"
+
+	// minimumGracePeriodInSeconds 停止容器时最短的宽限期
+	minimumGracePeriodInSeconds = 2
+
+	// defaultPath 容器没有设置PATH环境变量时使用的默认值，与docker保持一致
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// containerRootEnv 传给进程的容器根目录的环境变量名
+	containerRootEnv = "CONTAINER_ROOT"
+)
+
+// containerKillReason 容器被停止的原因，探针失败时可以使用探针上单独配置的宽限期
+type containerKillReason string
+
+const (
+	reasonStartupProbe        containerKillReason = "StartupProbe"
+	reasonLivenessProbe       containerKillReason = "LivenessProbe"
+	reasonFailedPostStartHook containerKillReason = "FailedPostStartHook"
+	reasonUnknown             containerKillReason = "Unknown"
+)
+
+var (
+	// ErrCreateContainerConfig 生成容器的配置（比如环境变量）失败
+	ErrCreateContainerConfig = errors.New("CreateContainerConfigError")
+	// ErrPostStartHook postStart钩子执行失败
+	ErrPostStartHook = errors.New("PostStartHookError")
+)
+
+// containerRecord 一个容器实例，也就是一个进程。容器每重启一次都会产生一个新的实例
+type containerRecord struct {
+	id      kubecontainer.ContainerID
+	podUID  types.UID
+	name    string
+	image   string
+	hash    uint64
+	attempt int
+
+	cmd   *exec.Cmd
+	pid   int
+	state kubecontainer.State
+
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	exitCode   int
+	reason     string
+	message    string
+
+	// logPath 日志文件的路径，stdout、stderr把进程的输出写到日志文件中，进程退出后关闭
+	logPath string
+	logFile *containerLogFile
+	stdout  *streamWriter
+	stderr  *streamWriter
+
+	// stdoutBroadcaster、stderrBroadcaster 进程实际的输出，写日志的同时转发给attach上来的客户端
+	stdoutBroadcaster *outputBroadcaster
+	stderrBroadcaster *outputBroadcaster
+	// stdin 容器设置了stdin时进程的标准输入，attach的客户端通过它输入；stdinOnce时第一个客户端结束后关闭
+	stdin     io.WriteCloser
+	stdinOnce bool
+	// pty 容器设置了tty时进程所在伪终端的主设备，outputDone在终端中的输出全部读完后关闭
+	pty        *os.File
+	outputDone chan struct{}
+
+	// exited 进程退出后关闭，此时日志已经全部写完
+	exited chan struct{}
+}
+
+// toContainer 转换为kubecontainer.Container，调用方需要持有锁
+func (c *containerRecord) toContainer() *kubecontainer.Container {
+	return &kubecontainer.Container{
+		ID:    c.id,
+		Name:  c.name,
+		Image: c.image,
+		Hash:  c.hash,
+		State: c.state,
+	}
+}
+
+// toStatus 转换为kubecontainer.Status，调用方需要持有锁
+func (c *containerRecord) toStatus() *kubecontainer.Status {
+	return &kubecontainer.Status{
+		ID:           c.id,
+		Name:         c.name,
+		State:        c.state,
+		CreatedAt:    c.createdAt,
+		StartedAt:    c.startedAt,
+		FinishedAt:   c.finishedAt,
+		ExitCode:     c.exitCode,
+		Image:        c.image,
+		Hash:         c.hash,
+		RestartCount: c.attempt,
+		Reason:       c.reason,
+		Message:      c.message,
+	}
+}
+
+// buildCommand 根据容器的command和args构造进程的启动命令
+func buildCommand(container *v1.Container) ([]string, error) {
+	if len(container.Command) == 0 {
+		return nil, fmt.Errorf("container %q has no command, the process runtime can not use the image entrypoint", container.Name)
+	}
+	return append(append([]string{}, container.Command...), container.Args...), nil
+}
+
+// buildEnv 把容器的环境变量转换为进程的环境变量，进程不会继承kubelet自身的环境变量
+func buildEnv(envs []kubecontainer.EnvVar, root string) []string {
+	env := make([]string, 0, len(envs)+2)
+	hasPath := false
+	for _, e := range envs {
+		if e.Name == "PATH" {
+			hasPath = true
+		}
+		env = append(env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+	if !hasPath {
+		env = append(env, "PATH="+defaultPath)
+	}
+	if root != "" {
+		env = append(env, fmt.Sprintf("%s=%s", containerRootEnv, root))
+	}
+	return env
+}
+
+// makeContainerRoot 进程运行时不能为进程单独挂载文件系统，卷按照下面的方式映射给进程：
+// 每个容器在pod目录下有一个根目录<podDir>/containers/<容器名>/rootfs，volumeMount的mountPath对应根目录下的同名路径，
+// 这个路径是指向卷在宿主机上目录的符号链接。根目录通过环境变量CONTAINER_ROOT传给进程，
+// 没有设置workingDir时进程的工作目录就是根目录。readOnly不会生效，也不支持嵌套的mountPath。
+// 容器每次启动都会重新创建根目录，pod没有目录时返回空字符串
+func (r *processRuntime) makeContainerRoot(pod *v1.Pod, container *v1.Container, mounts []kubecontainer.Mount) (string, error) {
+	podDir := r.runtimeHelper.GetPodDir(pod.UID)
+	if podDir == "" {
+		return "", nil
+	}
+	root := filepath.Join(podDir, config.DefaultKubeletContainersDirName, container.Name, "rootfs")
+	// 根目录中只有目录和符号链接，删除时不会影响卷中的内容
+	if err := os.RemoveAll(root); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(root, 0750); err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		paths = append(paths, filepath.Clean("/"+m.ContainerPath))
+	}
+	sort.Strings(paths)
+	for i := 1; i < len(paths); i++ {
+		if paths[i] == paths[i-1] || strings.HasPrefix(paths[i], paths[i-1]+"/") {
+			return "", fmt.Errorf("mount path %q is nested in %q, which is not supported by the process runtime", paths[i], paths[i-1])
+		}
+	}
+
+	for _, m := range mounts {
+		target := filepath.Join(root, filepath.Clean("/"+m.ContainerPath))
+		if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+			return "", err
+		}
+		if err := os.Symlink(m.HostPath, target); err != nil {
+			return "", fmt.Errorf("failed to map volume %q to %q: %v", m.Name, m.ContainerPath, err)
+		}
+	}
+	return root, nil
+}
+
+// startContainer 为容器启动一个新的进程实例，返回的字符串是失败时给用户看的信息
+func (r *processRuntime) startContainer(pod *v1.Pod, container *v1.Container) (string, error) {
+	command, err := buildCommand(container)
+	if err != nil {
+		r.recordContainerEvent(pod, container, v1.EventTypeWarning, events.FailedToCreateContainer, "Error: %v", err)
+		return err.Error(), kubecontainer.ErrRunContainer
+	}
+	opts, cleanupAction, err := r.runtimeHelper.GenerateRunContainerOptions(pod, container, r.podIP, []string{r.podIP})
+	if cleanupAction != nil {
+		defer cleanupAction()
+	}
+	if err != nil {
+		r.recordContainerEvent(pod, container, v1.EventTypeWarning, events.FailedToCreateContainer, "Error: %v", err)
+		return err.Error(), ErrCreateContainerConfig
+	}
+	r.lock.Lock()
+	record, ok := r.pods[pod.UID]
+	if !ok {
+		r.lock.Unlock()
+		return "pod is not created", kubecontainer.ErrRunContainer
+	}
+	attempt := 0
+	if latest := record.latestContainer(container.Name); latest != nil {
+		if latest.state == kubecontainer.ContainerStateRunning {
+			// 已经有运行中的实例，避免同一个容器被启动两次
+			r.lock.Unlock()
+			return "", nil
+		}
+		attempt = latest.attempt + 1
+	}
+	// 确认要启动新的实例之后才重建根目录，运行中的实例正在使用的根目录不能被删除
+	root, err := r.makeContainerRoot(pod, container, opts.Mounts)
+	if err != nil {
+		r.lock.Unlock()
+		r.recordContainerEvent(pod, container, v1.EventTypeWarning, events.FailedToCreateContainer, "Error: %v", err)
+		return err.Error(), ErrCreateContainerConfig
+	}
+	now := r.clock.Now()
+	c := &containerRecord{
+		id:        kubecontainer.BuildContainerID(RuntimeName, fmt.Sprintf("%s-%s-%d", pod.UID, container.Name, attempt)),
+		podUID:    pod.UID,
+		name:      container.Name,
+		image:     container.Image,
+		hash:      kubecontainer.HashContainer(container),
+		attempt:   attempt,
+		state:     kubecontainer.ContainerStateCreated,
+		createdAt: now,
+		exited:    make(chan struct{}),
+	}
+	record.containers = append(record.containers, c)
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = container.WorkingDir
+	if cmd.Dir == "" {
+		cmd.Dir = root
+	}
+	cmd.Env = buildEnv(opts.Envs, root)
+	if err := r.openContainerLog(pod, c); err != nil {
+		klog.ErrorS(err, "Failed to create container log, writing output to kubelet", "pod", klog.KObj(pod), "containerName", container.Name)
+	}
+	if c.logFile != nil {
+		c.stdoutBroadcaster = newOutputBroadcaster(c.stdout)
+		c.stderrBroadcaster = newOutputBroadcaster(c.stderr)
+	} else {
+		c.stdoutBroadcaster = newOutputBroadcaster(os.Stdout)
+		c.stderrBroadcaster = newOutputBroadcaster(os.Stderr)
+	}
+	ttyFile, err := c.setUpStdio(container, cmd)
+	if err == nil {
+		err = cmd.Start()
+		if ttyFile != nil {
+			// 从设备已经交给了进程，kubelet中的需要关闭，否则进程退出后读主设备不会结束
+			ttyFile.Close()
+		}
+	}
+	if err != nil {
+		if c.pty != nil {
+			c.pty.Close()
+		}
+		c.state = kubecontainer.ContainerStateExited
+		c.finishedAt = now
+		c.exitCode = startErrorExitCode
+		c.reason = reasonStartError
+		c.message = err.Error()
+		c.closeLog()
+		close(c.exited)
+		r.lock.Unlock()
+		r.recordContainerEvent(pod, container, v1.EventTypeWarning, events.FailedToStartContainer, "Error: %v", err)
+		r.notifyContainerState(c, kubecontainer.ContainerStateExited, startErrorExitCode)
+		return err.Error(), kubecontainer.ErrRunContainer
+	}
+	if c.pty != nil {
+		c.outputDone = make(chan struct{})
+		go func() {
+			defer close(c.outputDone)
+			io.Copy(c.stdoutBroadcaster, c.pty)
+		}()
+	}
+	c.cmd = cmd
+	c.pid = cmd.Process.Pid
+	c.state = kubecontainer.ContainerStateRunning
+	c.startedAt = r.clock.Now()
+	r.lock.Unlock()
+
+	klog.V(2).InfoS("Started container process", "pod", klog.KObj(pod), "containerName", container.Name, "pid", c.pid, "containerID", c.id)
+	r.recordContainerEvent(pod, container, v1.EventTypeNormal, events.StartedContainer, "Started container %s", container.Name)
+	go r.waitContainer(c)
+	r.notifyContainerState(c, kubecontainer.ContainerStateRunning, 0)
+
+	// 进程启动后执行postStart钩子，失败时杀死容器
+	if container.Lifecycle != nil && container.Lifecycle.PostStart != nil {
+		msg, handlerErr := r.runner.Run(c.id, pod, container, container.Lifecycle.PostStart)
+		if handlerErr != nil {
+			klog.ErrorS(handlerErr, "Failed to execute PostStartHook", "pod", klog.KObj(pod), "podUID", pod.UID,
+				"containerName", container.Name, "containerID", c.id.String())
+			r.recordContainerEvent(pod, container, v1.EventTypeWarning, events.FailedPostStartHook, "%s", msg)
+			if err := r.killContainer(pod, c, "FailedPostStartHook", reasonFailedPostStartHook, nil); err != nil {
+				klog.ErrorS(err, "Failed to kill container", "pod", klog.KObj(pod), "podUID", pod.UID,
+					"containerName", container.Name, "containerID", c.id.String())
+			}
+			return msg, ErrPostStartHook
+		}
+	}
+	return "", nil
+}
+
+// setUpStdio 设置容器进程的标准输入输出。设置了tty时进程运行在新分配的伪终端中，返回的从设备需要在进程启动后关闭；
+// 否则stdout、stderr分别写到对应的outputBroadcaster，设置了stdin时通过管道接收attach的客户端的输入。调用方需要持有锁
+func (c *containerRecord) setUpStdio(container *v1.Container, cmd *exec.Cmd) (*os.File, error) {
+	c.stdinOnce = container.StdinOnce
+	if container.TTY {
+		pty, tty, err := openPty()
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate tty: %v", err)
+		}
+		cmd.Stdin = tty
+		cmd.Stdout = tty
+		cmd.Stderr = tty
+		setTtySysProcAttr(cmd)
+		c.pty = pty
+		if container.Stdin {
+			c.stdin = pty
+		}
+		return tty, nil
+	}
+
+	cmd.Stdout = c.stdoutBroadcaster
+	cmd.Stderr = c.stderrBroadcaster
+	if container.Stdin {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		c.stdin = stdin
+	}
+	setSysProcAttr(cmd)
+	return nil, nil
+}
+
+// openContainerLog 创建容器实例的日志文件，pod没有目录时不创建，调用方需要持有锁
+func (r *processRuntime) openContainerLog(pod *v1.Pod, c *containerRecord) error {
+	podDir := r.runtimeHelper.GetPodDir(pod.UID)
+	if podDir == "" {
+		return nil
+	}
+	path := containerLogPath(podDir, c.name, c.attempt)
+	logFile, err := newContainerLogFile(path, r.containerLogMaxSize, r.containerLogMaxFiles, r.clock)
+	if err != nil {
+		return err
+	}
+	c.logPath = path
+	c.logFile = logFile
+	c.stdout = newStreamWriter(logFile, stdoutStream)
+	c.stderr = newStreamWriter(logFile, stderrStream)
+	return nil
+}
+
+// closeLog 写出最后的半行并关闭日志文件
+func (c *containerRecord) closeLog() {
+	if c.logFile == nil {
+		return
+	}
+	for _, w := range []*streamWriter{c.stdout, c.stderr} {
+		if err := w.Flush(); err != nil {
+			klog.ErrorS(err, "Failed to flush container log", "containerID", c.id, "path", c.logPath)
+		}
+	}
+	if err := c.logFile.Close(); err != nil {
+		klog.ErrorS(err, "Failed to close container log", "containerID", c.id, "path", c.logPath)
+	}
+}
+
+// waitContainer 等待进程退出，并记录退出码和结束时间
+func (r *processRuntime) waitContainer(c *containerRecord) {
+	err := c.cmd.Wait()
+	exitCode := exitCodeFromState(c.cmd.ProcessState)
+	if err != nil && c.cmd.ProcessState == nil {
+		exitCode = startErrorExitCode
+	}
+
+	// Wait返回时进程的输出已经全部交给了streamWriter，tty容器还需要等终端中的输出读完
+	if c.outputDone != nil {
+		<-c.outputDone
+		c.pty.Close()
+	}
+	c.closeLog()
+
+	r.lock.Lock()
+	c.state = kubecontainer.ContainerStateExited
+	c.finishedAt = r.clock.Now()
+	c.exitCode = exitCode
+	if exitCode == 0 {
+		c.reason = reasonCompleted
+	} else {
+		c.reason = reasonError
+	}
+	r.lock.Unlock()
+	close(c.exited)
+
+	klog.V(2).InfoS("Container process exited", "containerID", c.id, "pid", c.pid, "exitCode", exitCode)
+	r.notifyContainerState(c, kubecontainer.ContainerStateExited, exitCode)
+}
+
+// killContainer 先向容器进程所在的进程组发送SIGTERM，进程在宽限期内没有退出时再发送SIGKILL，并等待进程退出
+func (r *processRuntime) killContainer(pod *v1.Pod, c *containerRecord, message string, reason containerKillReason, gracePeriodOverride *int64) error {
+	var containerSpec *v1.Container
+	if pod != nil {
+		containerSpec = kubecontainer.GetContainerSpec(pod, c.name)
+	}
+
+	gracePeriod := int64(minimumGracePeriodInSeconds)
+	if pod != nil {
+		gracePeriod = setTerminationGracePeriod(pod, containerSpec, reason)
+	}
+	if gracePeriodOverride != nil {
+		gracePeriod = *gracePeriodOverride
+		klog.V(3).InfoS("Killing container with a grace period override", "pod", klog.KObj(pod), "podUID", c.podUID,
+			"containerName", c.name, "containerID", c.id, "gracePeriod", gracePeriod)
+	}
+
+	// 执行preStop钩子，钩子消耗的时间从宽限期中扣除
+	if containerSpec != nil && containerSpec.Lifecycle != nil && containerSpec.Lifecycle.PreStop != nil && gracePeriod > 0 {
+		gracePeriod = gracePeriod - r.executePreStopHook(pod, c, containerSpec, gracePeriod)
+	}
+	// always give containers a minimal shutdown window to avoid unnecessary SIGKILLs
+	if gracePeriod < minimumGracePeriodInSeconds {
+		gracePeriod = minimumGracePeriodInSeconds
+	}
+
+	if containerSpec != nil {
+		r.recordContainerEvent(pod, containerSpec, v1.EventTypeNormal, events.KillingContainer, message)
+	}
+	klog.V(2).InfoS("Killing container process with a grace period", "pod", klog.KObj(pod), "containerName", c.name,
+		"containerID", c.id, "pid", c.pid, "gracePeriod", gracePeriod)
+
+	if err := terminateProcessGroup(c.pid); err != nil {
+		select {
+		case <-c.exited:
+			return nil
+		default:
+		}
+		// SIGTERM发送失败时直接杀死进程
+		klog.V(2).InfoS("Failed to send SIGTERM to container process, killing it", "containerID", c.id, "pid", c.pid, "err", err)
+	} else {
+		timer := r.clock.NewTimer(time.Duration(gracePeriod) * time.Second)
+		select {
+		case <-c.exited:
+			timer.Stop()
+			klog.V(3).InfoS("Container exited normally", "pod", klog.KObj(pod), "containerName", c.name, "containerID", c.id)
+			return nil
+		case <-timer.C():
+		}
+		if containerSpec != nil {
+			r.recordContainerEvent(pod, containerSpec, v1.EventTypeWarning, events.ExceededGracePeriod,
+				"Container %s did not exit within the grace period of %d seconds, killing it", c.name, gracePeriod)
+		}
+		klog.V(2).InfoS("Container process exceeded its grace period, killing it", "pod", klog.KObj(pod), "containerName", c.name,
+			"containerID", c.id, "pid", c.pid, "gracePeriod", gracePeriod)
+	}
+
+	if err := killProcessGroup(c.pid); err != nil {
+		select {
+		case <-c.exited:
+			return nil
+		default:
+		}
+		return fmt.Errorf("failed to kill process %d of container %q: %v", c.pid, c.name, err)
+	}
+	<-c.exited
+	return nil
+}
+
+// executePreStopHook runs the pre-stop lifecycle hooks if applicable and returns the duration it takes.
+func (r *processRuntime) executePreStopHook(pod *v1.Pod, c *containerRecord, containerSpec *v1.Container, gracePeriod int64) int64 {
+	klog.V(3).InfoS("Running preStop hook", "pod", klog.KObj(pod), "podUID", pod.UID, "containerName", containerSpec.Name, "containerID", c.id.String())
+
+	start := r.clock.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer utilruntime.HandleCrash()
+		if msg, err := r.runner.Run(c.id, pod, containerSpec, containerSpec.Lifecycle.PreStop); err != nil {
+			klog.ErrorS(err, "PreStop hook failed", "pod", klog.KObj(pod), "podUID", pod.UID,
+				"containerName", containerSpec.Name, "containerID", c.id.String())
+			r.recordContainerEvent(pod, containerSpec, v1.EventTypeWarning, events.FailedPreStopHook, "%s", msg)
+		}
+	}()
+
+	timer := r.clock.NewTimer(time.Duration(gracePeriod) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		klog.V(2).InfoS("PreStop hook not completed in grace period", "pod", klog.KObj(pod), "podUID", pod.UID,
+			"containerName", containerSpec.Name, "containerID", c.id.String(), "gracePeriod", gracePeriod)
+	case <-done:
+		klog.V(3).InfoS("PreStop hook completed", "pod", klog.KObj(pod), "podUID", pod.UID,
+			"containerName", containerSpec.Name, "containerID", c.id.String())
+	}
+
+	return int64(r.clock.Since(start).Seconds())
+}
+
+// killContainerByID 根据ID停止容器实例
+func (r *processRuntime) killContainerByID(pod *v1.Pod, id kubecontainer.ContainerID, message string, reason containerKillReason) error {
+	r.lock.RLock()
+	c := r.findContainer(id)
+	r.lock.RUnlock()
+	if c == nil {
+		return fmt.Errorf("container %q not found", id.ID)
+	}
+	return r.killContainer(pod, c, message, reason, nil)
+}
+
+// setTerminationGracePeriod determines the grace period to use when killing a container
+func setTerminationGracePeriod(pod *v1.Pod, containerSpec *v1.Container, reason containerKillReason) int64 {
+	gracePeriod := int64(minimumGracePeriodInSeconds)
+	switch {
+	case pod.DeletionGracePeriodSeconds != nil:
+		return *pod.DeletionGracePeriodSeconds
+	case pod.Spec.TerminationGracePeriodSeconds != nil:
+		switch reason {
+		case reasonStartupProbe:
+			if containerSpec != nil && isProbeTerminationGracePeriodSecondsSet(containerSpec.StartupProbe) {
+				return *containerSpec.StartupProbe.TerminationGracePeriodSeconds
+			}
+		case reasonLivenessProbe:
+			if containerSpec != nil && isProbeTerminationGracePeriodSecondsSet(containerSpec.LivenessProbe) {
+				return *containerSpec.LivenessProbe.TerminationGracePeriodSeconds
+			}
+		}
+		return *pod.Spec.TerminationGracePeriodSeconds
+	}
+	return gracePeriod
+}
+
+func isProbeTerminationGracePeriodSecondsSet(probe *v1.Probe) bool {
+	return probe != nil && probe.TerminationGracePeriodSeconds != nil
+}
+
+// recordContainerEvent 记录容器相关的事件
+func (r *processRuntime) recordContainerEvent(pod *v1.Pod, container *v1.Container, eventType, reason, message string, args ...interface{}) {
+	ref, err := kubecontainer.GenerateContainerRef(pod, container)
+	if err != nil {
+		klog.ErrorS(err, "Can't make a container ref", "pod", klog.KObj(pod), "podUID", pod.UID, "containerName", container.Name)
+		return
+	}
+	r.recorder.Eventf(ref, eventType, reason, message, args...)
+}
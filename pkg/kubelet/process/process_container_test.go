@@ -0,0 +1,45 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/kubelet/config"
+)
+
+// TestStartContainerAlreadyRunning starts a container that already has a
+// running instance, as a sync acting on a stale pod status would, and checks
+// that neither a second instance is started nor the root of the running one
+// is rebuilt.
+func TestStartContainerAlreadyRunning(t *testing.T) {
+	r, _ := startTestRuntime(t)
+	pod := newTestPod()
+	container := &pod.Spec.Containers[0]
+
+	root := filepath.Join(r.runtimeHelper.GetPodDir(pod.UID), config.DefaultKubeletContainersDirName, container.Name, "rootfs")
+	marker := filepath.Join(root, "marker")
+	if err := os.WriteFile(marker, []byte("running"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := r.startContainer(pod, container)
+	if err != nil {
+		t.Fatalf("unexpected error: %v (%s)", err, msg)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected the root of the running container to be kept: %v", err)
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var instances int
+	for _, c := range r.pods[pod.UID].containers {
+		if c.name == container.Name {
+			instances++
+		}
+	}
+	if instances != 1 {
+		t.Errorf("expected 1 instance of container %q, got %d", container.Name, instances)
+	}
+}
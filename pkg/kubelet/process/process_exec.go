@@ -0,0 +1,80 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	execprobe "k8s.io/kubernetes/pkg/probe/exec"
+	utilexec "k8s.io/utils/exec"
+)
+
+// RunInContainer 在容器的工作目录和环境变量下执行命令，返回合并后的stdout和stderr。
+// 进程运行时没有隔离，命令直接运行在宿主机上，主要用于exec探针
+func (r *processRuntime) RunInContainer(id kubecontainer.ContainerID, cmd []string, timeout time.Duration) ([]byte, error) {
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("empty command for container %q", id.ID)
+	}
+
+	c, err := r.getRunningContainer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	command := exec.Command(cmd[0], cmd[1:]...)
+	command.Dir = c.cmd.Dir
+	command.Env = c.cmd.Env
+	command.Stdout = &output
+	command.Stderr = &output
+	setSysProcAttr(command)
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case err := <-done:
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			code := exitCodeFromState(exitErr.ProcessState)
+			return output.Bytes(), utilexec.CodeExitError{
+				Err:  fmt.Errorf("command '%s' exited with %d: %s", strings.Join(cmd, " "), code, output.String()),
+				Code: code,
+			}
+		}
+		return output.Bytes(), err
+	case <-timeoutCh:
+		// 命令可能启动了子进程，整个进程组一起杀掉
+		if err := killProcessGroup(command.Process.Pid); err != nil {
+			klog.V(4).InfoS("Failed to kill timed out command", "containerID", id, "pid", command.Process.Pid, "err", err)
+		}
+		<-done
+		return output.Bytes(), execprobe.NewTimeoutError(fmt.Errorf("command '%s' timed out after %s", strings.Join(cmd, " "), timeout), timeout)
+	}
+}
+
+// findContainer 根据ID查找容器实例，调用方需要持有锁
+func (r *processRuntime) findContainer(id kubecontainer.ContainerID) *containerRecord {
+	for _, record := range r.pods {
+		for _, c := range record.containers {
+			if c.id == id {
+				return c
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package process
+
+import (
+	v1 "k8s.io/api/core/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+)
+
+// 进程运行时直接执行宿主机上的可执行文件，不需要拉取镜像。
+// 镜像相关的接口都只是把容器中的image字段原样返回。
+
+// PullImage 不会拉取任何东西，镜像名即为镜像引用
+func (r *processRuntime) PullImage(image kubecontainer.ImageSpec, pullSecrets []v1.Secret, podSandboxConfig *runtimeapi.PodSandboxConfig) (string, error) {
+	return image.Image, nil
+}
+
+// GetImageRef 镜像名即为镜像引用
+func (r *processRuntime) GetImageRef(image kubecontainer.ImageSpec) (string, error) {
+	return image.Image, nil
+}
+
+// ListImages 进程运行时没有本地镜像
+func (r *processRuntime) ListImages() ([]kubecontainer.Image, error) {
+	return []kubecontainer.Image{}, nil
+}
+
+// RemoveImage 进程运行时没有本地镜像
+func (r *processRuntime) RemoveImage(image kubecontainer.ImageSpec) error {
+	return nil
+}
+
+// ImageStats 进程运行时的镜像不占用存储
+func (r *processRuntime) ImageStats() (*kubecontainer.ImageStats, error) {
+	return &kubecontainer.ImageStats{}, nil
+}
@@ -0,0 +1,99 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// setSysProcAttr 让容器进程成为新进程组的组长，停止容器时可以连同子进程一起处理
+func setSysProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// setTtySysProcAttr 让进程成为新会话的首进程，并把标准输入（伪终端的从设备）作为控制终端。
+// 新会话的进程组ID就是进程的pid，停止时仍然可以按进程组处理
+func setTtySysProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+}
+
+// openPty 分配一对伪终端，pty是主设备，由kubelet读写，tty是从设备，作为进程的标准输入输出
+func openPty() (pty, tty *os.File, err error) {
+	pty, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			pty.Close()
+		}
+	}()
+
+	var n uint32
+	err = ptyControl(pty, func(fd int) error {
+		if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+			return err
+		}
+		var err error
+		n, err = unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	tty, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pty, tty, nil
+}
+
+// setTerminalSize 调整伪终端的大小，终端中的进程会收到SIGWINCH
+func setTerminalSize(pty *os.File, size remotecommand.TerminalSize) error {
+	return ptyControl(pty, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Row: size.Height, Col: size.Width})
+	})
+}
+
+// ptyControl 在伪终端的文件描述符上执行ioctl。不使用Fd()，避免把文件切换为阻塞模式后Close无法打断正在进行的读
+func ptyControl(pty *os.File, fn func(fd int) error) error {
+	conn, err := pty.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := conn.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	}); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// terminateProcessGroup 向进程所在的进程组发送SIGTERM，通知进程优雅退出
+func terminateProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGTERM)
+}
+
+// killProcessGroup 向进程所在的进程组发送SIGKILL
+func killProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// exitCodeFromState 获取进程的退出码，被信号终止的进程按照shell的约定返回128+信号值
+func exitCodeFromState(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
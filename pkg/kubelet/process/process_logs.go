@@ -0,0 +1,483 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// 日志中的流名称和标签，与CRI日志格式保持一致
+	stdoutStream = "stdout"
+	stderrStream = "stderr"
+	// tagPartial 一行日志被拆成了多条，后面还有内容
+	tagPartial = "P"
+	// tagFull 一行日志的最后一条
+	tagFull = "F"
+
+	// logTimestampFormat 日志中的时间格式，与containerd保持一致，固定宽度便于按时间排序
+	logTimestampFormat = "2006-01-02T15:04:05.000000000Z07:00"
+	// maxLogLineSize 超过这个长度的行会被拆成多条partial日志，与containerd保持一致
+	maxLogLineSize = 16 * 1024
+	// rotatedLogTimestampFormat 轮转后的日志文件名中的时间格式。kubelet的container log manager精确到秒，
+	// 这里在写入时同步轮转，一秒内可能轮转多次，所以精确到微秒
+	rotatedLogTimestampFormat = "20060102-150405.000000"
+
+	// logPollPeriod follow日志时检查新内容的周期
+	logPollPeriod = 100 * time.Millisecond
+)
+
+// ContainerLogDir 容器的日志目录：<podDir>/<容器名>，容器每个实例的日志文件都在这个目录中
+func ContainerLogDir(podDir, containerName string) string {
+	return filepath.Join(podDir, containerName)
+}
+
+// containerLogPath 容器实例的日志文件：<podDir>/<容器名>/<重启次数>.log
+func containerLogPath(podDir, containerName string, attempt int) string {
+	return filepath.Join(ContainerLogDir(podDir, containerName), fmt.Sprintf("%d.log", attempt))
+}
+
+// containerLogFile 容器实例的日志文件，标准输出和标准错误写到同一个文件中，超过大小上限时轮转
+type containerLogFile struct {
+	lock  sync.Mutex
+	path  string
+	file  *os.File
+	size  int64
+	clock clock.Clock
+
+	// maxSize 单个文件的大小上限，maxFiles 保留的文件数，包括正在写的文件
+	maxSize  int64
+	maxFiles int
+}
+
+// newContainerLogFile 创建日志文件，已经存在时追加写入
+func newContainerLogFile(path string, maxSize int64, maxFiles int, clock clock.Clock) (*containerLogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &containerLogFile{path: path, clock: clock, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *containerLogFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// writeLog 写一条CRI格式的日志：<时间> <流> <标签> <内容>
+func (f *containerLogFile) writeLog(stream, tag string, log []byte) error {
+	line := make([]byte, 0, len(logTimestampFormat)+len(stream)+len(tag)+len(log)+4)
+	line = append(line, f.clock.Now().Format(logTimestampFormat)...)
+	line = append(line, ' ')
+	line = append(line, stream...)
+	line = append(line, ' ')
+	line = append(line, tag...)
+	line = append(line, ' ')
+	line = append(line, log...)
+	line = append(line, '\n')
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return fmt.Errorf("log file %q is closed", f.path)
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// 轮转失败时继续写当前文件，避免丢失日志
+			klog.ErrorS(err, "Failed to rotate container log", "path", f.path)
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// rotate 把当前文件重命名为<文件名>.<时间>，打开新文件，并删除超出数量的旧文件，调用方需要持有锁
+func (f *containerLogFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	rotated := fmt.Sprintf("%s.%s", f.path, f.clock.Now().Format(rotatedLogTimestampFormat))
+	if err := os.Rename(f.path, rotated); err != nil {
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return removeExcessLogs(f.path, f.maxFiles)
+}
+
+func (f *containerLogFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotatedLogs 返回日志文件轮转后的旧文件，从旧到新排列
+func rotatedLogs(path string) ([]string, error) {
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	// 文件名中的时间格式固定宽度，按名称排序就是按时间排序
+	sort.Strings(files)
+	return files, nil
+}
+
+// removeExcessLogs 只保留maxFiles-1个轮转后的旧文件
+func removeExcessLogs(path string, maxFiles int) error {
+	if maxFiles <= 0 {
+		return nil
+	}
+	files, err := rotatedLogs(path)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(files)-(maxFiles-1); i++ {
+		if err := os.Remove(files[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeContainerLogs 删除容器实例的所有日志文件
+func removeContainerLogs(path string) {
+	if path == "" {
+		return
+	}
+	files, err := rotatedLogs(path)
+	if err != nil {
+		klog.ErrorS(err, "Failed to list rotated container logs", "path", path)
+	}
+	for _, file := range append(files, path) {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			klog.ErrorS(err, "Failed to remove container log", "path", file)
+		}
+	}
+}
+
+// streamWriter 把进程的一个输出流按行写到日志文件中，作为exec.Cmd的Stdout或Stderr
+type streamWriter struct {
+	file   *containerLogFile
+	stream string
+	buf    []byte
+}
+
+func newStreamWriter(file *containerLogFile, stream string) *streamWriter {
+	return &streamWriter{file: file, stream: stream}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		if err := w.writeLine(tagFull, w.buf[:idx]); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[idx+1:]
+	}
+	for len(w.buf) >= maxLogLineSize {
+		if err := w.writeLine(tagPartial, w.buf[:maxLogLineSize]); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[maxLogLineSize:]
+	}
+	return len(p), nil
+}
+
+// writeLine 拆分过长的行，与containerd一样，只有一行的最后一条是tagFull
+func (w *streamWriter) writeLine(tag string, line []byte) error {
+	for len(line) > maxLogLineSize {
+		if err := w.file.writeLog(w.stream, tagPartial, line[:maxLogLineSize]); err != nil {
+			return err
+		}
+		line = line[maxLogLineSize:]
+	}
+	return w.file.writeLog(w.stream, tag, line)
+}
+
+// Flush 进程退出后写出最后一行没有换行符的内容
+func (w *streamWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(tagFull, w.buf)
+	w.buf = nil
+	return err
+}
+
+// logMessage CRI格式日志中的一条
+type logMessage struct {
+	timestamp time.Time
+	stream    string
+	tag       string
+	log       []byte
+}
+
+// parseCRILog 解析一条CRI格式的日志：<时间> <流> <标签> <内容>，line不包括结尾的换行符
+func parseCRILog(line []byte, msg *logMessage) error {
+	fields := bytes.SplitN(line, []byte{' '}, 4)
+	if len(fields) < 3 {
+		return fmt.Errorf("invalid CRI log line %q", line)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+	if err != nil {
+		return fmt.Errorf("unexpected timestamp format %q: %v", fields[0], err)
+	}
+	msg.timestamp = timestamp
+	msg.stream = string(fields[1])
+	if msg.stream != stdoutStream && msg.stream != stderrStream {
+		return fmt.Errorf("unexpected stream type %q", fields[1])
+	}
+	msg.tag = string(fields[2])
+	msg.log = nil
+	if len(fields) == 4 {
+		msg.log = fields[3]
+	}
+	return nil
+}
+
+// logWriter 按照PodLogOptions把日志写给调用方
+type logWriter struct {
+	stdout io.Writer
+	stderr io.Writer
+	opts   *v1.PodLogOptions
+	// remain limitBytes剩余的字节数，小于0表示没有限制
+	remain int64
+}
+
+// errMaximumWrite 已经写满了limitBytes
+var errMaximumWrite = fmt.Errorf("maximum write")
+
+func newLogWriter(stdout, stderr io.Writer, opts *v1.PodLogOptions) *logWriter {
+	w := &logWriter{stdout: stdout, stderr: stderr, opts: opts, remain: -1}
+	if opts.LimitBytes != nil {
+		w.remain = *opts.LimitBytes
+	}
+	return w
+}
+
+// write 写一条日志，tagFull的日志结尾加上换行符
+func (w *logWriter) write(msg *logMessage) error {
+	if w.opts.SinceTime != nil && msg.timestamp.Before(w.opts.SinceTime.Time) {
+		return nil
+	}
+	line := msg.log
+	if msg.tag == tagFull {
+		line = append(line[:len(line):len(line)], '\n')
+	}
+	if w.opts.Timestamps {
+		line = append([]byte(msg.timestamp.Format(time.RFC3339Nano)+" "), line...)
+	}
+	out := w.stdout
+	if msg.stream == stderrStream {
+		out = w.stderr
+	}
+	if out == nil {
+		return nil
+	}
+	if w.remain >= 0 && int64(len(line)) > w.remain {
+		line = line[:w.remain]
+	}
+	n, err := out.Write(line)
+	if err != nil {
+		return err
+	}
+	if w.remain >= 0 {
+		w.remain -= int64(n)
+		if w.remain == 0 {
+			return errMaximumWrite
+		}
+	}
+	return nil
+}
+
+// readContainerLogs 读取容器实例的日志，包括轮转后的旧文件。
+// opts.SinceSeconds需要由调用方转换为SinceTime。follow时持续读取新的内容，直到ctx结束，或者容器已经退出且没有新的内容
+func readContainerLogs(ctx context.Context, path string, opts *v1.PodLogOptions, exited <-chan struct{}, stdout, stderr io.Writer) error {
+	rotated, err := rotatedLogs(path)
+	if err != nil {
+		return err
+	}
+	var messages []logMessage
+	for _, file := range rotated {
+		if messages, err = appendLogFile(messages, file); err != nil {
+			return err
+		}
+	}
+	// 当前文件保持打开，follow时从读到的位置继续
+	current, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { current.Close() }()
+	reader := bufio.NewReader(current)
+	messages, pending, err := appendLogs(messages, reader, path)
+	if err != nil {
+		return err
+	}
+
+	start := 0
+	if opts.TailLines != nil {
+		start = tailStart(messages, *opts.TailLines)
+	}
+	w := newLogWriter(stdout, stderr, opts)
+	for i := start; i < len(messages); i++ {
+		if err := w.write(&messages[i]); err != nil {
+			return ignoreMaximumWrite(err)
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(logPollPeriod)
+	defer ticker.Stop()
+	finished := false
+	for {
+		var newMessages []logMessage
+		newMessages, pending, err = appendLogs(nil, reader, path, pending...)
+		if err != nil {
+			return err
+		}
+		for i := range newMessages {
+			if err := w.write(&newMessages[i]); err != nil {
+				return ignoreMaximumWrite(err)
+			}
+		}
+		if len(newMessages) > 0 {
+			continue
+		}
+		// 文件被轮转后切换到新文件。重命名之后旧文件不会再被写入，上面已经把它读完
+		if rotatedAway(current, path) {
+			if next, err := os.Open(path); err == nil {
+				current.Close()
+				current, pending = next, nil
+				reader.Reset(current)
+				continue
+			}
+		}
+		if finished {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-exited:
+			// 容器已经退出，日志已经全部写完，再读一次后结束
+			finished = true
+		case <-ticker.C:
+		}
+	}
+}
+
+// ignoreMaximumWrite 写满limitBytes不是错误
+func ignoreMaximumWrite(err error) error {
+	if err == errMaximumWrite {
+		return nil
+	}
+	return err
+}
+
+// appendLogFile 读取一个日志文件中的所有日志
+func appendLogFile(messages []logMessage, path string) ([]logMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 读取的过程中旧文件可能已经被删除
+			return messages, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	messages, _, err = appendLogs(messages, bufio.NewReader(f), path)
+	return messages, err
+}
+
+// appendLogs 读取reader中所有完整的日志，pending是上次读到的半行。
+// 返回最后没有换行符的半行，文件还在被写入时，下次读取时传入
+func appendLogs(messages []logMessage, reader *bufio.Reader, path string, pending ...byte) ([]logMessage, []byte, error) {
+	for {
+		line, err := reader.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err != nil {
+			if err == io.EOF {
+				return messages, pending, nil
+			}
+			return nil, nil, err
+		}
+		var msg logMessage
+		if err := parseCRILog(pending[:len(pending)-1], &msg); err != nil {
+			klog.ErrorS(err, "Failed to parse container log", "path", path)
+		} else {
+			messages = append(messages, msg)
+		}
+		pending = nil
+	}
+}
+
+// tailStart 返回最后tail行日志开始的位置，被拆成多条的一行算作一行
+func tailStart(messages []logMessage, tail int64) int {
+	if tail < 0 {
+		return 0
+	}
+	lines := int64(0)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].tag == tagFull {
+			if lines == tail {
+				return i + 1
+			}
+			lines++
+		}
+	}
+	return 0
+}
+
+// rotatedAway 打开的文件是否已经不是path，也就是已经被轮转
+func rotatedAway(f *os.File, path string) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(opened, current)
+}
@@ -0,0 +1,709 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/events"
+	"k8s.io/kubernetes/pkg/kubelet/lifecycle"
+	proberesults "k8s.io/kubernetes/pkg/kubelet/prober/results"
+	"k8s.io/kubernetes/pkg/kubelet/util/format"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// RuntimeName 进程运行时的名称，同时也是ContainerID中的Type
+	RuntimeName = "process"
+	// runtimeAPIVersion 进程运行时的版本
+	runtimeAPIVersion = "0.1.0"
+)
+
+// ErrContainerRunning 删除仍在运行的容器时返回
+var ErrContainerRunning = errors.New("container is still running")
+
+// ProcessRuntime 进程运行时：不依赖任何容器引擎，pod中的每个容器对应宿主机上的一个进程
+type ProcessRuntime interface {
+	kubecontainer.Runtime
+	kubecontainer.CommandRunner
+	// DirectStreamingRuntime exec、attach和port-forward由kubelet的HTTPS服务直接处理
+	kubecontainer.DirectStreamingRuntime
+	// AddContainerStateListener 注册容器进程状态变化的回调
+	AddContainerStateListener(listener ContainerStateListener)
+	// ListPodStats 返回运行中的pod及其容器的CPU和内存使用情况
+	ListPodStats() ([]statsapi.PodStats, error)
+	// RemovePod 删除已经停止的pod的运行记录及其所有容器实例，pod中还有运行中的容器时返回错误
+	RemovePod(uid types.UID) error
+}
+
+// ContainerStateListener 容器进程启动(ContainerStateRunning)或者退出(ContainerStateExited)时的回调，
+// exitCode只在退出时有意义
+type ContainerStateListener func(podUID types.UID, containerName string, state kubecontainer.State, exitCode int)
+
+// podRecord 运行时内部记录的pod
+type podRecord struct {
+	uid       types.UID
+	name      string
+	namespace string
+	createdAt time.Time
+	// ready 相当于sandbox的状态，KillPod之后变为false
+	ready bool
+	// containers 该pod下所有的容器实例，包括已经退出的
+	containers []*containerRecord
+}
+
+// processRuntime 进程运行时的实现
+type processRuntime struct {
+	// lock 保护pods以及其中所有容器实例的状态
+	lock sync.RWMutex
+	pods map[types.UID]*podRecord
+
+	recorder record.EventRecorder
+	clock    clock.Clock
+	// livenessManager、startupManager 探针结果，探测失败的容器会被杀死并按重启策略重新拉起
+	livenessManager proberesults.Manager
+	startupManager  proberesults.Manager
+	// runtimeHelper 提供启动容器需要的信息，比如容器的环境变量
+	runtimeHelper kubecontainer.RuntimeHelper
+	// runner 执行容器的postStart和preStop钩子
+	runner kubecontainer.HandlerRunner
+	// listeners 容器进程状态变化的回调，受lock保护
+	listeners []ContainerStateListener
+	// podIP 进程都运行在宿主机网络中，所有pod共用宿主机IP
+	podIP string
+	// containerLogMaxSize 单个日志文件的大小上限，containerLogMaxFiles 每个容器实例保留的日志文件数
+	containerLogMaxSize  int64
+	containerLogMaxFiles int
+
+	// statsLock 保护cpuUsageCache，cpuUsageCache 容器ID -> 上一次统计时的CPU使用时间
+	statsLock     sync.Mutex
+	cpuUsageCache map[string]*cpuUsageRecord
+}
+
+var _ ProcessRuntime = &processRuntime{}
+
+// NewProcessRuntime 创建进程运行时，容器的输出写到pod目录下的日志文件中，超过containerLogMaxSize时轮转，
+// 每个容器实例最多保留containerLogMaxFiles个文件
+func NewProcessRuntime(recorder record.EventRecorder, runtimeHelper kubecontainer.RuntimeHelper, livenessManager, startupManager proberesults.Manager,
+	containerLogMaxSize int64, containerLogMaxFiles int) ProcessRuntime {
+	r := &processRuntime{
+		pods:                 map[types.UID]*podRecord{},
+		recorder:             recorder,
+		clock:                clock.RealClock{},
+		livenessManager:      livenessManager,
+		startupManager:       startupManager,
+		runtimeHelper:        runtimeHelper,
+		podIP:                hostIP(),
+		containerLogMaxSize:  containerLogMaxSize,
+		containerLogMaxFiles: containerLogMaxFiles,
+		cpuUsageCache:        make(map[string]*cpuUsageRecord),
+	}
+	// exec钩子通过运行时自身的RunInContainer执行，httpGet钩子访问pod的IP
+	r.runner = lifecycle.NewHandlerRunner(&http.Client{}, r, r)
+	return r
+}
+
+// hostIP 获取宿主机的IP，获取失败时使用回环地址
+func hostIP() string {
+	ip, err := utilnet.ChooseHostInterface()
+	if err != nil {
+		klog.ErrorS(err, "Failed to choose host interface, falling back to loopback address")
+		return "127.0.0.1"
+	}
+	return ip.String()
+}
+
+// Type 运行时类型
+func (r *processRuntime) Type() string {
+	return RuntimeName
+}
+
+// SupportsSingleFileMapping 进程直接运行在宿主机上，不存在文件映射
+func (r *processRuntime) SupportsSingleFileMapping() bool {
+	return false
+}
+
+// Version 运行时版本
+func (r *processRuntime) Version() (kubecontainer.Version, error) {
+	return utilversion.ParseSemantic(runtimeAPIVersion)
+}
+
+// APIVersion 运行时API版本
+func (r *processRuntime) APIVersion() (kubecontainer.Version, error) {
+	return utilversion.ParseSemantic(runtimeAPIVersion)
+}
+
+// Status 进程运行时没有外部依赖，始终是就绪的
+func (r *processRuntime) Status() (*kubecontainer.RuntimeStatus, error) {
+	return &kubecontainer.RuntimeStatus{
+		Conditions: []kubecontainer.RuntimeCondition{
+			{Type: kubecontainer.RuntimeReady, Status: true},
+			{Type: kubecontainer.NetworkReady, Status: true},
+		},
+	}, nil
+}
+
+// GetPods 获取运行时中的pod，all为false时只返回有运行中容器的pod
+func (r *processRuntime) GetPods(all bool) ([]*kubecontainer.Pod, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	pods := make([]*kubecontainer.Pod, 0, len(r.pods))
+	for _, record := range r.pods {
+		pod := &kubecontainer.Pod{
+			ID:        record.uid,
+			Name:      record.name,
+			Namespace: record.namespace,
+		}
+		for _, c := range record.containers {
+			if !all && c.state != kubecontainer.ContainerStateRunning {
+				continue
+			}
+			pod.Containers = append(pod.Containers, c.toContainer())
+		}
+		if !all && !record.ready && len(pod.Containers) == 0 {
+			continue
+		}
+		pod.Sandboxes = append(pod.Sandboxes, &kubecontainer.Container{
+			ID:    kubecontainer.BuildContainerID(RuntimeName, string(record.uid)),
+			State: kubecontainer.SandboxToContainerState(record.sandboxState()),
+		})
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// GetPodStatus 获取pod的状态，容器按创建时间从新到旧排列
+func (r *processRuntime) GetPodStatus(uid types.UID, name, namespace string) (*kubecontainer.PodStatus, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	status := &kubecontainer.PodStatus{
+		ID:        uid,
+		Name:      name,
+		Namespace: namespace,
+	}
+	record, ok := r.pods[uid]
+	if !ok {
+		return status, nil
+	}
+	status.IPs = []string{r.podIP}
+	status.SandboxStatuses = []*runtimeapi.PodSandboxStatus{
+		{
+			Id: string(record.uid),
+			Metadata: &runtimeapi.PodSandboxMetadata{
+				Name:      record.name,
+				Uid:       string(record.uid),
+				Namespace: record.namespace,
+			},
+			State:     record.sandboxState(),
+			CreatedAt: record.createdAt.UnixNano(),
+			Network:   &runtimeapi.PodSandboxNetworkStatus{Ip: r.podIP},
+		},
+	}
+	for _, c := range record.containers {
+		status.ContainerStatuses = append(status.ContainerStatuses, c.toStatus())
+	}
+	sort.Sort(sort.Reverse(kubecontainer.SortContainerStatusesByCreationTime(status.ContainerStatuses)))
+	return status, nil
+}
+
+// podActions 一次SyncPod需要执行的动作
+type podActions struct {
+	// CreateSandbox 为true表示需要(重新)创建pod的运行记录
+	CreateSandbox bool
+	// NextInitContainerToStart 下一个需要启动的init容器，init容器全部成功退出之前不会启动普通容器
+	NextInitContainerToStart *v1.Container
+	// ContainersToStart 需要启动的容器在pod.Spec.Containers中的下标
+	ContainersToStart []int
+	// ContainersToKill 需要停止的容器实例，比如存活探针失败的容器
+	ContainersToKill map[kubecontainer.ContainerID]containerToKillInfo
+}
+
+// containerToKillInfo 需要停止的容器的信息
+type containerToKillInfo struct {
+	// name 容器名
+	name string
+	// message 停止的原因，会记录到事件中
+	message string
+	// reason 停止的原因，用于决定宽限期
+	reason containerKillReason
+}
+
+// findNextInitContainerToRun returns the status of the last failed container, the
+// index of next init container to start, or done if there are no further init containers.
+// Status is only returned if an init container is failed, in which case next will
+// point to the current container.
+func findNextInitContainerToRun(pod *v1.Pod, podStatus *kubecontainer.PodStatus) (status *kubecontainer.Status, next *v1.Container, done bool) {
+	if len(pod.Spec.InitContainers) == 0 {
+		return nil, nil, true
+	}
+
+	// If any of the main containers have status and are Running, then all init containers must
+	// have been executed at some point in the past.  However, they could have been removed
+	// from the container runtime now, and if we proceed, it would appear as if they
+	// never ran and will re-execute improperly.
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		status := podStatus.FindContainerStatusByName(container.Name)
+		if status != nil && status.State == kubecontainer.ContainerStateRunning {
+			return nil, nil, true
+		}
+	}
+
+	// If there are failed containers, return the status of the last failed one.
+	for i := len(pod.Spec.InitContainers) - 1; i >= 0; i-- {
+		container := &pod.Spec.InitContainers[i]
+		status := podStatus.FindContainerStatusByName(container.Name)
+		if status != nil && isInitContainerFailed(status) {
+			return status, container, false
+		}
+	}
+
+	// There are no failed containers now.
+	for i := len(pod.Spec.InitContainers) - 1; i >= 0; i-- {
+		container := &pod.Spec.InitContainers[i]
+		status := podStatus.FindContainerStatusByName(container.Name)
+		if status == nil {
+			continue
+		}
+
+		// container is still running, return not done.
+		if status.State == kubecontainer.ContainerStateRunning {
+			return nil, nil, false
+		}
+
+		if status.State == kubecontainer.ContainerStateExited {
+			// all init containers successful
+			if i == (len(pod.Spec.InitContainers) - 1) {
+				return nil, nil, true
+			}
+
+			// all containers up to i successful, go to i+1
+			return nil, &pod.Spec.InitContainers[i+1], false
+		}
+	}
+
+	return nil, &pod.Spec.InitContainers[0], false
+}
+
+// isInitContainerFailed init容器是否失败，非0退出或者状态未知都视为失败
+func isInitContainerFailed(status *kubecontainer.Status) bool {
+	if status.State == kubecontainer.ContainerStateExited && status.ExitCode != 0 {
+		return true
+	}
+
+	if status.State == kubecontainer.ContainerStateUnknown {
+		return true
+	}
+
+	return false
+}
+
+// shouldRestartOnFailure 容器失败后是否需要重启
+func shouldRestartOnFailure(pod *v1.Pod) bool {
+	return pod.Spec.RestartPolicy != v1.RestartPolicyNever
+}
+
+// computePodActions 对比pod的期望状态与当前状态，计算出需要执行的动作
+func (r *processRuntime) computePodActions(pod *v1.Pod, podStatus *kubecontainer.PodStatus) podActions {
+	changes := podActions{
+		CreateSandbox:    !r.isPodReady(pod.UID),
+		ContainersToKill: make(map[kubecontainer.ContainerID]containerToKillInfo),
+	}
+
+	// 检查init容器的进度，init容器按顺序逐个运行
+	initLastStatus, next, done := findNextInitContainerToRun(pod, podStatus)
+	if !done {
+		if next != nil {
+			initFailed := initLastStatus != nil && isInitContainerFailed(initLastStatus)
+			if initFailed && !shouldRestartOnFailure(pod) {
+				// 重启策略为Never时init容器失败，不再启动任何容器，pod的phase会变为Failed
+				klog.V(3).InfoS("Init container failed and pod will not be restarted", "containerName", next.Name, "pod", klog.KObj(pod))
+			} else {
+				changes.NextInitContainerToStart = next
+			}
+		}
+		// Initialization failed or still in progress. Skip inspecting non-init
+		// containers.
+		return changes
+	}
+
+	for idx, container := range pod.Spec.Containers {
+		status := podStatus.FindContainerStatusByName(container.Name)
+		if status == nil || status.State != kubecontainer.ContainerStateRunning {
+			// 没有运行的容器按照重启策略决定是否启动：Always任何退出都重启，OnFailure只在退出码非0时重启，
+			// Never不再重启。重启的退避由SyncPod中的doBackOff处理
+			if kubecontainer.ShouldContainerBeRestarted(&container, pod, podStatus) {
+				klog.V(3).InfoS("Container of pod is not in the desired state and shall be started", "containerName", container.Name, "pod", klog.KObj(pod))
+				changes.ContainersToStart = append(changes.ContainersToStart, idx)
+			}
+			continue
+		}
+
+		// 容器正在运行，探针失败时需要杀死容器
+		var message string
+		var reason containerKillReason
+		if liveness, found := r.livenessManager.Get(status.ID); found && liveness == proberesults.Failure {
+			message = fmt.Sprintf("Container %s failed liveness probe", container.Name)
+			reason = reasonLivenessProbe
+		} else if startup, found := r.startupManager.Get(status.ID); found && startup == proberesults.Failure {
+			message = fmt.Sprintf("Container %s failed startup probe", container.Name)
+			reason = reasonStartupProbe
+		} else {
+			continue
+		}
+		if shouldRestartOnFailure(pod) {
+			message = fmt.Sprintf("%s, will be restarted", message)
+			changes.ContainersToStart = append(changes.ContainersToStart, idx)
+		}
+		changes.ContainersToKill[status.ID] = containerToKillInfo{
+			name:    container.Name,
+			message: message,
+			reason:  reason,
+		}
+		klog.V(2).InfoS("Message for Container of pod", "containerName", container.Name, "containerStatusID", status.ID, "pod", klog.KObj(pod), "containerMessage", message)
+	}
+	return changes
+}
+
+// SyncPod 让pod中的进程达到期望状态
+func (r *processRuntime) SyncPod(pod *v1.Pod, podStatus *kubecontainer.PodStatus, pullSecrets []v1.Secret, backOff *flowcontrol.Backoff) (result kubecontainer.PodSyncResult) {
+	podContainerChanges := r.computePodActions(pod, podStatus)
+	klog.V(3).InfoS("computePodActions got for pod", "podActions", podContainerChanges, "pod", klog.KObj(pod))
+
+	if podContainerChanges.CreateSandbox {
+		createSandboxResult := kubecontainer.NewSyncResult(kubecontainer.CreatePodSandbox, format.Pod(pod))
+		result.AddSyncResult(createSandboxResult)
+		r.createPodRecord(pod)
+	}
+
+	// 先停止需要停止的容器
+	for containerID, containerInfo := range podContainerChanges.ContainersToKill {
+		klog.V(3).InfoS("Killing unwanted container for pod", "containerName", containerInfo.name, "containerID", containerID, "pod", klog.KObj(pod))
+		killContainerResult := kubecontainer.NewSyncResult(kubecontainer.KillContainer, containerInfo.name)
+		result.AddSyncResult(killContainerResult)
+		if err := r.killContainerByID(pod, containerID, containerInfo.message, containerInfo.reason); err != nil {
+			killContainerResult.Fail(kubecontainer.ErrKillContainer, err.Error())
+			klog.ErrorS(err, "killContainer for pod failed", "containerName", containerInfo.name, "containerID", containerID, "pod", klog.KObj(pod))
+			return
+		}
+	}
+
+	// start 检查退避后启动一个容器，init容器和普通容器共用
+	var resultLock sync.Mutex
+	start := func(typeName string, container *v1.Container) error {
+		startContainerResult := kubecontainer.NewSyncResult(kubecontainer.StartContainer, container.Name)
+		resultLock.Lock()
+		result.AddSyncResult(startContainerResult)
+		resultLock.Unlock()
+
+		// 重启容器之前检查是否处于退避中
+		isInBackOff, msg, err := r.doBackOff(pod, container, backOff)
+		if isInBackOff {
+			startContainerResult.Fail(err, msg)
+			klog.V(4).InfoS("Backing Off restarting container in pod", "containerType", typeName, "containerName", container.Name, "pod", klog.KObj(pod))
+			return err
+		}
+
+		klog.V(4).InfoS("Creating container in pod", "containerType", typeName, "containerName", container.Name, "pod", klog.KObj(pod))
+		if msg, err := r.startContainer(pod, container); err != nil {
+			startContainerResult.Fail(err, msg)
+			klog.V(3).InfoS("Container start failed", "pod", klog.KObj(pod), "containerName", container.Name, "containerType", typeName, "err", err)
+			return err
+		}
+		return nil
+	}
+
+	// 启动下一个init容器，它退出后PLEG会触发下一次同步
+	if container := podContainerChanges.NextInitContainerToStart; container != nil {
+		if err := start("init container", container); err != nil {
+			return
+		}
+		klog.V(4).InfoS("Started init container for pod", "containerName", container.Name, "pod", klog.KObj(pod))
+	}
+
+	// 普通容器并发启动，各自独立运行，互不等待
+	wg := sync.WaitGroup{}
+	wg.Add(len(podContainerChanges.ContainersToStart))
+	for _, idx := range podContainerChanges.ContainersToStart {
+		go func(container *v1.Container) {
+			defer wg.Done()
+			start("container", container)
+		}(&pod.Spec.Containers[idx])
+	}
+	wg.Wait()
+	return
+}
+
+// AddContainerStateListener 注册容器进程状态变化的回调
+func (r *processRuntime) AddContainerStateListener(listener ContainerStateListener) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.listeners = append(r.listeners, listener)
+}
+
+// notifyContainerState 通知容器进程的状态变化，调用方不能持有锁
+func (r *processRuntime) notifyContainerState(c *containerRecord, state kubecontainer.State, exitCode int) {
+	r.lock.RLock()
+	listeners := r.listeners
+	r.lock.RUnlock()
+	for _, listener := range listeners {
+		listener(c.podUID, c.name, state, exitCode)
+	}
+}
+
+// doBackOff 检查容器是否处于重启退避中。以最近一次退出的时间作为起点，
+// 退避期间返回kubecontainer.ErrCrashLoopBackOff，ReasonCache会把它展示为容器的等待原因
+func (r *processRuntime) doBackOff(pod *v1.Pod, container *v1.Container, backOff *flowcontrol.Backoff) (bool, string, error) {
+	r.lock.RLock()
+	var latest *containerRecord
+	if record, ok := r.pods[pod.UID]; ok {
+		latest = record.latestContainer(container.Name)
+	}
+	if latest == nil || latest.state != kubecontainer.ContainerStateExited {
+		r.lock.RUnlock()
+		return false, "", nil
+	}
+	ts := latest.finishedAt
+	r.lock.RUnlock()
+
+	klog.V(3).InfoS("Checking backoff for container in pod", "containerName", container.Name, "pod", klog.KObj(pod))
+	// backOff requires a unique key to identify the container.
+	key := getStableKey(pod, container)
+	if backOff.IsInBackOffSince(key, ts) {
+		r.recordContainerEvent(pod, container, v1.EventTypeWarning, events.BackOffStartContainer, "Back-off restarting failed container")
+		err := fmt.Errorf("back-off %s restarting failed container=%s pod=%s", backOff.Get(key), container.Name, format.Pod(pod))
+		klog.V(3).InfoS("Back-off restarting failed container", "err", err.Error())
+		return true, err.Error(), kubecontainer.ErrCrashLoopBackOff
+	}
+
+	backOff.Next(key, ts)
+	return false, "", nil
+}
+
+// getStableKey 生成容器在退避中使用的key，容器的定义发生变化后会重新计算退避
+func getStableKey(pod *v1.Pod, container *v1.Container) string {
+	hash := strconv.FormatUint(kubecontainer.HashContainer(container), 16)
+	return fmt.Sprintf("%s_%s_%s_%s_%s", pod.Name, pod.Namespace, string(pod.UID), container.Name, hash)
+}
+
+// KillPod 停止pod中所有运行中的进程。进程先收到SIGTERM，在宽限期内没有退出的进程会被SIGKILL杀死，
+// 宽限期优先使用gracePeriodOverride，否则使用pod的terminationGracePeriodSeconds
+func (r *processRuntime) KillPod(pod *v1.Pod, runningPod kubecontainer.Pod, gracePeriodOverride *int64) error {
+	r.lock.Lock()
+	record, ok := r.pods[runningPod.ID]
+	var running []*containerRecord
+	if ok {
+		record.ready = false
+		for _, c := range record.containers {
+			if c.state == kubecontainer.ContainerStateRunning {
+				running = append(running, c)
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	result := kubecontainer.PodSyncResult{}
+	resultCh := make(chan *kubecontainer.SyncResult, len(running))
+	wg := sync.WaitGroup{}
+	wg.Add(len(running))
+	for _, c := range running {
+		go func(c *containerRecord) {
+			defer wg.Done()
+			killContainerResult := kubecontainer.NewSyncResult(kubecontainer.KillContainer, c.name)
+			if err := r.killContainer(pod, c, "Stopping container", reasonUnknown, gracePeriodOverride); err != nil {
+				killContainerResult.Fail(kubecontainer.ErrKillContainer, err.Error())
+				klog.ErrorS(err, "Kill container failed", "pod", klog.KRef(runningPod.Namespace, runningPod.Name), "podUID", runningPod.ID,
+					"containerName", c.name, "containerID", c.id)
+			}
+			resultCh <- killContainerResult
+		}(c)
+	}
+	wg.Wait()
+	close(resultCh)
+	for res := range resultCh {
+		result.AddSyncResult(res)
+	}
+	return result.Error()
+}
+
+// GetContainerLogs 从容器实例的日志文件中读取日志，follow时直到容器退出或者ctx结束才返回
+func (r *processRuntime) GetContainerLogs(ctx context.Context, pod *v1.Pod, containerID kubecontainer.ContainerID, logOptions *v1.PodLogOptions, stdout, stderr io.Writer) error {
+	r.lock.RLock()
+	c := r.findContainer(containerID)
+	var logPath string
+	var exited <-chan struct{}
+	if c != nil {
+		logPath, exited = c.logPath, c.exited
+	}
+	r.lock.RUnlock()
+	if c == nil {
+		return fmt.Errorf("container %q not found", containerID.ID)
+	}
+	if logPath == "" {
+		return fmt.Errorf("process runtime does not keep logs of container %q", containerID.ID)
+	}
+
+	opts := logOptions.DeepCopy()
+	if opts.SinceSeconds != nil {
+		since := metav1.NewTime(r.clock.Now().Add(-time.Duration(*opts.SinceSeconds) * time.Second))
+		opts.SinceTime = &since
+	}
+	return readContainerLogs(ctx, logPath, opts, exited, stdout, stderr)
+}
+
+// DeleteContainer 删除已经退出的容器实例
+func (r *processRuntime) DeleteContainer(containerID kubecontainer.ContainerID) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, record := range r.pods {
+		for i, c := range record.containers {
+			if c.id != containerID {
+				continue
+			}
+			if c.state == kubecontainer.ContainerStateRunning {
+				return fmt.Errorf("failed to delete container %q: %w", containerID.ID, ErrContainerRunning)
+			}
+			record.containers = append(record.containers[:i], record.containers[i+1:]...)
+			removeContainerLogs(c.logPath)
+			return nil
+		}
+	}
+	return nil
+}
+
+// GarbageCollect 按照gcPolicy清理已经退出的容器实例，以及已经停止且没有任何容器的pod
+func (r *processRuntime) GarbageCollect(gcPolicy kubecontainer.GCPolicy, allSourcesReady bool, evictNonDeletedPods bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now()
+	for uid, record := range r.pods {
+		// 按容器名分组，每组从新到旧排列
+		byName := map[string][]*containerRecord{}
+		for _, c := range record.containers {
+			if c.state == kubecontainer.ContainerStateRunning {
+				continue
+			}
+			byName[c.name] = append(byName[c.name], c)
+		}
+		evict := map[*containerRecord]bool{}
+		for _, dead := range byName {
+			sort.Slice(dead, func(i, j int) bool { return dead[i].createdAt.After(dead[j].createdAt) })
+			keep := gcPolicy.MaxPerPodContainer
+			if !record.ready && evictNonDeletedPods {
+				keep = 0
+			} else if keep < 0 {
+				keep = len(dead)
+			}
+			for i := keep; i < len(dead); i++ {
+				if gcPolicy.MinAge > 0 && now.Sub(dead[i].createdAt) < gcPolicy.MinAge {
+					continue
+				}
+				evict[dead[i]] = true
+			}
+		}
+		containers := record.containers[:0]
+		for _, c := range record.containers {
+			if !evict[c] {
+				containers = append(containers, c)
+			} else {
+				removeContainerLogs(c.logPath)
+			}
+		}
+		record.containers = containers
+		if !record.ready && len(record.containers) == 0 {
+			delete(r.pods, uid)
+		}
+	}
+	return nil
+}
+
+// RemovePod 删除已经停止的pod的运行记录，之后GetPods和GetPodStatus中不再有这个pod
+func (r *processRuntime) RemovePod(uid types.UID) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	record, ok := r.pods[uid]
+	if !ok {
+		return nil
+	}
+	for _, c := range record.containers {
+		if c.state == kubecontainer.ContainerStateRunning {
+			return fmt.Errorf("failed to remove pod %q: container %q: %w", uid, c.id.ID, ErrContainerRunning)
+		}
+	}
+	for _, c := range record.containers {
+		removeContainerLogs(c.logPath)
+	}
+	delete(r.pods, uid)
+	return nil
+}
+
+// UpdatePodCIDR 进程运行在宿主机网络中，忽略podCIDR
+func (r *processRuntime) UpdatePodCIDR(podCIDR string) error {
+	return nil
+}
+
+// isPodReady pod是否已经创建且没有被停止
+func (r *processRuntime) isPodReady(uid types.UID) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	record, ok := r.pods[uid]
+	return ok && record.ready
+}
+
+// createPodRecord 创建pod的运行记录，pod被停止后重新创建时会保留历史容器实例
+func (r *processRuntime) createPodRecord(pod *v1.Pod) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	record, ok := r.pods[pod.UID]
+	if !ok {
+		record = &podRecord{
+			uid:       pod.UID,
+			name:      pod.Name,
+			namespace: pod.Namespace,
+		}
+		r.pods[pod.UID] = record
+	}
+	record.createdAt = r.clock.Now()
+	record.ready = true
+}
+
+// sandboxState pod运行记录对应的sandbox状态
+func (p *podRecord) sandboxState() runtimeapi.PodSandboxState {
+	if p.ready {
+		return runtimeapi.PodSandboxState_SANDBOX_READY
+	}
+	return runtimeapi.PodSandboxState_SANDBOX_NOTREADY
+}
+
+// latestContainer 某个容器最新的实例，调用方需要持有锁
+func (p *podRecord) latestContainer(name string) *containerRecord {
+	var latest *containerRecord
+	for _, c := range p.containers {
+		if c.name == name && (latest == nil || c.createdAt.After(latest.createdAt)) {
+			latest = c
+		}
+	}
+	return latest
+}
@@ -0,0 +1,409 @@
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/procfs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+)
+
+const (
+	// cgroupV2Dir cgroup v2的挂载点，只有这里是unified hierarchy时才读取cgroup中的统计
+	cgroupV2Dir = "/sys/fs/cgroup"
+	// userHZ /proc/<pid>/stat中CPU时间的单位，Linux上固定为每秒100个tick
+	userHZ = 100
+
+	// statsapi中没有磁盘IO的字段，容器进程累计读写的字节数通过UserDefinedMetrics返回
+	ioReadBytesMetric  = "io_read_bytes"
+	ioWriteBytesMetric = "io_write_bytes"
+)
+
+// cpuUsageRecord 上一次统计时容器累计使用的CPU时间，用于计算两次统计之间的UsageNanoCores
+type cpuUsageRecord struct {
+	usageCoreNanoSeconds uint64
+	time                 time.Time
+}
+
+// podStatsTarget 需要统计的pod，在持有锁时从podRecord中复制出来，统计时不再持有锁
+type podStatsTarget struct {
+	ref        statsapi.PodReference
+	startTime  time.Time
+	containers []containerStatsTarget
+}
+
+type containerStatsTarget struct {
+	id        string
+	name      string
+	pid       int
+	startTime time.Time
+}
+
+// processInfo 容器进程组中的一个进程
+type processInfo struct {
+	proc procfs.Proc
+	stat procfs.ProcStat
+}
+
+// ListPodStats 统计运行中的pod的容器的CPU、内存和磁盘IO。容器进程及其子进程属于同一个进程组，
+// 容器进程有单独的cgroup v2时CPU和内存使用cgroup中的统计，否则汇总进程组中所有进程的/proc统计。
+// 只返回CPU和内存，日志和卷的磁盘使用由调用方统计
+func (r *processRuntime) ListPodStats() ([]statsapi.PodStats, error) {
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %v", err)
+	}
+
+	targets := r.podStatsTargets()
+	pgids := make(map[int]bool)
+	for _, pod := range targets {
+		for _, c := range pod.containers {
+			pgids[c.pid] = true
+		}
+	}
+	groups, err := listProcessGroups(fs, pgids)
+	if err != nil {
+		return nil, err
+	}
+	selfCgroup := ""
+	if self, err := fs.Self(); err == nil {
+		selfCgroup = cgroupV2Path(self)
+	}
+
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+
+	seen := make(map[string]bool)
+	result := make([]statsapi.PodStats, 0, len(targets))
+	for _, pod := range targets {
+		podStats := statsapi.PodStats{
+			PodRef:     pod.ref,
+			StartTime:  metav1.NewTime(pod.startTime),
+			Containers: make([]statsapi.ContainerStats, 0, len(pod.containers)),
+		}
+		var processCount uint64
+		for _, c := range pod.containers {
+			procs := groups[c.pid]
+			if len(procs) == 0 {
+				// 进程已经退出，等待运行时更新容器状态
+				continue
+			}
+			seen[c.id] = true
+			processCount += uint64(len(procs))
+			podStats.Containers = append(podStats.Containers, r.containerStats(c, procs, selfCgroup))
+		}
+		podStats.CPU, podStats.Memory = sumPodCPUAndMemory(podStats.Containers)
+		podStats.ProcessStats = &statsapi.ProcessStats{ProcessCount: &processCount}
+		result = append(result, podStats)
+	}
+	for id := range r.cpuUsageCache {
+		if !seen[id] {
+			delete(r.cpuUsageCache, id)
+		}
+	}
+	return result, nil
+}
+
+// podStatsTargets 复制出就绪的pod和其中正在运行的容器
+func (r *processRuntime) podStatsTargets() []podStatsTarget {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	targets := make([]podStatsTarget, 0, len(r.pods))
+	for _, record := range r.pods {
+		if !record.ready {
+			continue
+		}
+		target := podStatsTarget{
+			ref: statsapi.PodReference{
+				Name:      record.name,
+				Namespace: record.namespace,
+				UID:       string(record.uid),
+			},
+			startTime: record.createdAt,
+		}
+		for _, c := range record.containers {
+			if c.state != kubecontainer.ContainerStateRunning || c.pid == 0 {
+				continue
+			}
+			target.containers = append(target.containers, containerStatsTarget{
+				id:        c.id.ID,
+				name:      c.name,
+				pid:       c.pid,
+				startTime: c.startedAt,
+			})
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// containerStats 统计一个容器的CPU、内存和磁盘IO，调用方需要持有statsLock
+func (r *processRuntime) containerStats(c containerStatsTarget, procs []processInfo, selfCgroup string) statsapi.ContainerStats {
+	now := r.clock.Now()
+	stats := statsapi.ContainerStats{
+		Name:      c.name,
+		StartTime: metav1.NewTime(c.startTime),
+	}
+
+	// 容器进程和kubelet在同一个cgroup中时，cgroup的统计包含了kubelet自身，只能按进程统计
+	if path := cgroupV2Path(procs[0].proc); path != "" && path != selfCgroup {
+		cpu, memory, err := readCgroupStats(filepath.Join(cgroupV2Dir, path), now)
+		if err == nil {
+			stats.CPU, stats.Memory = cpu, memory
+		} else {
+			klog.V(4).InfoS("Failed to read cgroup stats of container, falling back to process stats", "containerID", c.id, "cgroup", path, "err", err)
+		}
+	}
+	if stats.CPU == nil {
+		stats.CPU, stats.Memory = processGroupCPUAndMemory(procs, now)
+	}
+	r.updateCPUNanoCoreUsage(c.id, stats.CPU)
+	stats.UserDefinedMetrics = processGroupIO(procs, now)
+	return stats
+}
+
+// updateCPUNanoCoreUsage 根据上一次统计的结果计算UsageNanoCores，并记录本次的结果，调用方需要持有statsLock
+func (r *processRuntime) updateCPUNanoCoreUsage(id string, cpu *statsapi.CPUStats) {
+	if cpu.UsageCoreNanoSeconds == nil {
+		return
+	}
+	usage := *cpu.UsageCoreNanoSeconds
+	if cached, ok := r.cpuUsageCache[id]; ok && cpu.Time.Time.After(cached.time) && usage >= cached.usageCoreNanoSeconds {
+		nanoCores := uint64(float64(usage-cached.usageCoreNanoSeconds) / cpu.Time.Time.Sub(cached.time).Seconds())
+		cpu.UsageNanoCores = &nanoCores
+	}
+	r.cpuUsageCache[id] = &cpuUsageRecord{usageCoreNanoSeconds: usage, time: cpu.Time.Time}
+}
+
+// listProcessGroups 找出进程组ID在pgids中的所有进程，按进程组分组，组长排在第一个。
+// 容器进程是进程组的组长，进程组ID就是容器进程的pid
+func listProcessGroups(fs procfs.FS, pgids map[int]bool) (map[int][]processInfo, error) {
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %v", err)
+	}
+	groups := make(map[int][]processInfo)
+	for _, p := range procs {
+		stat, err := p.Stat()
+		if err != nil {
+			// 进程可能已经退出
+			continue
+		}
+		if !pgids[stat.PGRP] {
+			continue
+		}
+		info := processInfo{proc: p, stat: stat}
+		if p.PID == stat.PGRP {
+			groups[stat.PGRP] = append([]processInfo{info}, groups[stat.PGRP]...)
+		} else {
+			groups[stat.PGRP] = append(groups[stat.PGRP], info)
+		}
+	}
+	// 组长已经退出的进程组不再属于任何容器
+	for pgid, group := range groups {
+		if group[0].proc.PID != pgid {
+			delete(groups, pgid)
+		}
+	}
+	return groups, nil
+}
+
+// processGroupCPUAndMemory 汇总进程组中所有进程的CPU和内存。
+// CPU时间包含进程已经回收的子进程的时间，内存的使用量和工作集都是进程的常驻内存
+func processGroupCPUAndMemory(procs []processInfo, now time.Time) (*statsapi.CPUStats, *statsapi.MemoryStats) {
+	var ticks, rss, workingSet, pageFaults, majorPageFaults uint64
+	for _, p := range procs {
+		ticks += uint64(p.stat.UTime) + uint64(p.stat.STime) + uint64(p.stat.CUTime) + uint64(p.stat.CSTime)
+		pageFaults += uint64(p.stat.MinFlt)
+		majorPageFaults += uint64(p.stat.MajFlt)
+		status, err := p.proc.NewStatus()
+		if err != nil {
+			continue
+		}
+		workingSet += status.VmRSS
+		rss += status.RssAnon
+	}
+	usageCoreNanoSeconds := ticks * (uint64(time.Second) / userHZ)
+	usageBytes := workingSet
+	cpu := &statsapi.CPUStats{
+		Time:                 metav1.NewTime(now),
+		UsageCoreNanoSeconds: &usageCoreNanoSeconds,
+	}
+	memory := &statsapi.MemoryStats{
+		Time:            metav1.NewTime(now),
+		UsageBytes:      &usageBytes,
+		WorkingSetBytes: &workingSet,
+		RSSBytes:        &rss,
+		PageFaults:      &pageFaults,
+		MajorPageFaults: &majorPageFaults,
+	}
+	return cpu, memory
+}
+
+// processGroupIO 汇总进程组中所有进程累计读写磁盘的字节数，没有权限读取/proc/<pid>/io的进程会被忽略
+func processGroupIO(procs []processInfo, now time.Time) []statsapi.UserDefinedMetric {
+	var readBytes, writeBytes uint64
+	for _, p := range procs {
+		io, err := p.proc.IO()
+		if err != nil {
+			continue
+		}
+		readBytes += io.ReadBytes
+		writeBytes += io.WriteBytes
+	}
+	metric := func(name string, value uint64) statsapi.UserDefinedMetric {
+		return statsapi.UserDefinedMetric{
+			UserDefinedMetricDescriptor: statsapi.UserDefinedMetricDescriptor{
+				Name:  name,
+				Type:  statsapi.MetricCumulative,
+				Units: "bytes",
+			},
+			Time:  metav1.NewTime(now),
+			Value: float64(value),
+		}
+	}
+	return []statsapi.UserDefinedMetric{
+		metric(ioReadBytesMetric, readBytes),
+		metric(ioWriteBytesMetric, writeBytes),
+	}
+}
+
+// sumPodCPUAndMemory 把pod中所有容器的CPU和内存加起来作为pod的统计，容器的统计不完整时对应的字段为空
+func sumPodCPUAndMemory(containers []statsapi.ContainerStats) (*statsapi.CPUStats, *statsapi.MemoryStats) {
+	if len(containers) == 0 {
+		return nil, nil
+	}
+	cpu := &statsapi.CPUStats{Time: containers[0].CPU.Time}
+	memory := &statsapi.MemoryStats{Time: containers[0].Memory.Time}
+	var usageCoreNanoSeconds, usageNanoCores, usageBytes, workingSetBytes, rssBytes uint64
+	hasNanoCores := true
+	for _, c := range containers {
+		usageCoreNanoSeconds += *c.CPU.UsageCoreNanoSeconds
+		if c.CPU.UsageNanoCores != nil {
+			usageNanoCores += *c.CPU.UsageNanoCores
+		} else {
+			hasNanoCores = false
+		}
+		usageBytes += *c.Memory.UsageBytes
+		workingSetBytes += *c.Memory.WorkingSetBytes
+		if c.Memory.RSSBytes != nil {
+			rssBytes += *c.Memory.RSSBytes
+		}
+	}
+	cpu.UsageCoreNanoSeconds = &usageCoreNanoSeconds
+	if hasNanoCores {
+		cpu.UsageNanoCores = &usageNanoCores
+	}
+	memory.UsageBytes = &usageBytes
+	memory.WorkingSetBytes = &workingSetBytes
+	memory.RSSBytes = &rssBytes
+	return cpu, memory
+}
+
+// cgroupV2Path 进程在cgroup v2中的路径，系统没有使用cgroup v2时返回空
+func cgroupV2Path(p procfs.Proc) string {
+	if _, err := os.Stat(filepath.Join(cgroupV2Dir, "cgroup.controllers")); err != nil {
+		return ""
+	}
+	cgroups, err := p.Cgroups()
+	if err != nil {
+		return ""
+	}
+	for _, cgroup := range cgroups {
+		if cgroup.HierarchyID == 0 && len(cgroup.Controllers) == 0 {
+			return cgroup.Path
+		}
+	}
+	return ""
+}
+
+// readCgroupStats 读取cgroup v2中的CPU和内存统计。工作集与cAdvisor的算法一致，为使用量减去不活跃的文件缓存
+func readCgroupStats(dir string, now time.Time) (*statsapi.CPUStats, *statsapi.MemoryStats, error) {
+	cpuStat, err := readCgroupKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, nil, err
+	}
+	usageUsec, ok := cpuStat["usage_usec"]
+	if !ok {
+		return nil, nil, fmt.Errorf("usage_usec not found in cpu.stat of %s", dir)
+	}
+	usageBytes, err := readCgroupUint(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil, nil, err
+	}
+	memoryStat, err := readCgroupKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usageCoreNanoSeconds := usageUsec * uint64(time.Microsecond)
+	workingSetBytes := usageBytes
+	if inactiveFile := memoryStat["inactive_file"]; inactiveFile < workingSetBytes {
+		workingSetBytes -= inactiveFile
+	} else {
+		workingSetBytes = 0
+	}
+	rssBytes := memoryStat["anon"]
+	pageFaults := memoryStat["pgfault"]
+	majorPageFaults := memoryStat["pgmajfault"]
+	memory := &statsapi.MemoryStats{
+		Time:            metav1.NewTime(now),
+		UsageBytes:      &usageBytes,
+		WorkingSetBytes: &workingSetBytes,
+		RSSBytes:        &rssBytes,
+		PageFaults:      &pageFaults,
+		MajorPageFaults: &majorPageFaults,
+	}
+	// memory.max为max表示没有限制，此时不返回可用内存
+	if limit, err := readCgroupUint(filepath.Join(dir, "memory.max")); err == nil && limit > workingSetBytes {
+		availableBytes := limit - workingSetBytes
+		memory.AvailableBytes = &availableBytes
+	}
+	cpu := &statsapi.CPUStats{
+		Time:                 metav1.NewTime(now),
+		UsageCoreNanoSeconds: &usageCoreNanoSeconds,
+	}
+	return cpu, memory, nil
+}
+
+// readCgroupUint 读取只有一个整数的cgroup文件
+func readCgroupUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readCgroupKeyValues 读取每行为"<key> <value>"格式的cgroup文件，比如cpu.stat和memory.stat
+func readCgroupKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,320 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog/v2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// eotCharacter 伪终端中的EOF控制字符（Ctrl-D）
+	eotCharacter = 0x04
+
+	// portForwardDialTimeout 连接pod中端口的超时时间
+	portForwardDialTimeout = 5 * time.Second
+	// portForwardCloseTimeout 一个方向的转发结束后，等待另一个方向结束的时间
+	portForwardCloseTimeout = time.Second
+)
+
+// ExecInContainer 在容器的工作目录和环境变量下执行命令，命令的标准输入输出直接连接到客户端的流上。
+// 进程运行时不会为容器创建namespace，容器进程和kubelet共用宿主机的namespace，命令也就和容器运行在同样的namespace中。
+// tty为true时命令运行在新分配的伪终端中，终端大小随客户端的resize事件调整
+func (r *processRuntime) ExecInContainer(id kubecontainer.ContainerID, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool,
+	resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	if len(cmd) == 0 {
+		return fmt.Errorf("empty command for container %q", id.ID)
+	}
+	c, err := r.getRunningContainer(id)
+	if err != nil {
+		return err
+	}
+
+	command := exec.Command(cmd[0], cmd[1:]...)
+	command.Dir = c.cmd.Dir
+	command.Env = c.cmd.Env
+
+	// outputDone tty模式下终端中的输出全部转发给客户端后关闭
+	var outputDone chan struct{}
+	if tty {
+		pty, ttyFile, err := openPty()
+		if err != nil {
+			return fmt.Errorf("failed to allocate tty: %v", err)
+		}
+		defer pty.Close()
+		command.Stdin = ttyFile
+		command.Stdout = ttyFile
+		command.Stderr = ttyFile
+		setTtySysProcAttr(command)
+		kubecontainer.HandleResizing(resize, func(size remotecommand.TerminalSize) {
+			if err := setTerminalSize(pty, size); err != nil {
+				klog.V(4).InfoS("Failed to resize tty", "containerID", id, "err", err)
+			}
+		})
+		err = command.Start()
+		// 子进程已经持有从设备，kubelet中的不再需要，否则进程退出后读主设备不会结束
+		ttyFile.Close()
+		if err != nil {
+			return err
+		}
+		if stdin != nil {
+			go io.Copy(pty, stdin)
+		}
+		outputDone = make(chan struct{})
+		go func() {
+			defer close(outputDone)
+			if stdout != nil {
+				io.Copy(stdout, pty)
+			} else {
+				io.Copy(io.Discard, pty)
+			}
+		}()
+	} else {
+		if stdin != nil {
+			// 使用StdinPipe而不是直接设置Stdin，进程退出后Wait不会等待客户端关闭标准输入
+			w, err := command.StdinPipe()
+			if err != nil {
+				return err
+			}
+			go func() {
+				io.Copy(w, stdin)
+				w.Close()
+			}()
+		}
+		if stdout != nil {
+			command.Stdout = stdout
+		}
+		if stderr != nil {
+			command.Stderr = stderr
+		}
+		setSysProcAttr(command)
+		if err := command.Start(); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-timeoutCh:
+		if err := killProcessGroup(command.Process.Pid); err != nil {
+			klog.V(4).InfoS("Failed to kill timed out command", "containerID", id, "pid", command.Process.Pid, "err", err)
+		}
+		<-done
+		return fmt.Errorf("command '%s' timed out after %s", strings.Join(cmd, " "), timeout)
+	}
+	if outputDone != nil {
+		<-outputDone
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code := exitCodeFromState(exitErr.ProcessState)
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command '%s' exited with %d", strings.Join(cmd, " "), code),
+			Code: code,
+		}
+	}
+	return err
+}
+
+// AttachContainer 把客户端连接到正在运行的容器进程上：容器之后的输出会同时写给客户端，
+// 容器设置了stdin时客户端的输入写到进程的标准输入，设置了tty时可以调整终端大小。
+// 容器退出、客户端关闭标准输入或者连接断开时结束，stdinOnce的容器在客户端关闭标准输入后也会关闭进程的标准输入
+func (r *processRuntime) AttachContainer(id kubecontainer.ContainerID, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool,
+	resize <-chan remotecommand.TerminalSize) error {
+	c, err := r.getRunningContainer(id)
+	if err != nil {
+		return err
+	}
+
+	if c.pty != nil {
+		kubecontainer.HandleResizing(resize, func(size remotecommand.TerminalSize) {
+			if err := setTerminalSize(c.pty, size); err != nil {
+				klog.V(4).InfoS("Failed to resize tty", "containerID", id, "err", err)
+			}
+		})
+	}
+
+	detached := make(chan struct{})
+	var once sync.Once
+	detach := func() {
+		once.Do(func() {
+			close(detached)
+		})
+	}
+	if stdout != nil {
+		client := c.stdoutBroadcaster.add(stdout, detach)
+		defer c.stdoutBroadcaster.remove(client)
+	}
+	// tty容器的stdout和stderr合并在终端中，都通过stdout输出
+	if stderr != nil && c.pty == nil {
+		client := c.stderrBroadcaster.add(stderr, detach)
+		defer c.stderrBroadcaster.remove(client)
+	}
+	if stdin != nil && c.stdin != nil {
+		go func() {
+			defer detach()
+			if _, err := io.Copy(c.stdin, stdin); err != nil {
+				klog.V(4).InfoS("Failed to copy stdin to container", "containerID", id, "err", err)
+			}
+			if c.stdinOnce {
+				c.closeStdin()
+			}
+		}()
+	}
+
+	select {
+	case <-c.exited:
+	case <-detached:
+	}
+	return nil
+}
+
+// PortForward 把stream和pod中的端口连接起来，在两者之间双向复制数据。
+// 进程运行时的pod都运行在宿主机网络中，相当于hostNetwork的pod，优先连接localhost上的端口，
+// 连不上时再连接pod IP（即宿主机IP），兼容只监听在宿主机IP上的进程
+func (r *processRuntime) PortForward(pod *kubecontainer.Pod, port int32, stream io.ReadWriteCloser) error {
+	podFullName := kubecontainer.BuildPodFullName(pod.Name, pod.Namespace)
+	r.lock.RLock()
+	record, ok := r.pods[pod.ID]
+	ready := ok && record.ready
+	r.lock.RUnlock()
+	if !ready {
+		return fmt.Errorf("pod %q is not running", podFullName)
+	}
+
+	var conn net.Conn
+	var err error
+	for _, host := range []string{"localhost", r.podIP} {
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))), portForwardDialTimeout)
+		if err == nil {
+			break
+		}
+		klog.V(4).InfoS("Failed to connect to port of pod", "pod", podFullName, "host", host, "port", port, "err", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to port %d of pod %q: %v", port, podFullName, err)
+	}
+	defer conn.Close()
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stream, conn)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, stream)
+		// 客户端不再发送数据，关闭连接的写方向通知pod中的进程，读方向继续转发剩余的响应
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+		errCh <- err
+	}()
+
+	// 任意一个方向结束后，给另一个方向留一点时间把剩余的数据转发完
+	errFwd := <-errCh
+	select {
+	case err := <-errCh:
+		if errFwd == nil {
+			errFwd = err
+		}
+	case <-time.After(portForwardCloseTimeout):
+		klog.V(4).InfoS("Timed out waiting for port forwarding to finish", "pod", podFullName, "port", port)
+	}
+	return errFwd
+}
+
+// closeStdin 关闭容器进程的标准输入。tty容器的标准输入就是伪终端，发送EOF控制字符代替关闭
+func (c *containerRecord) closeStdin() {
+	var err error
+	if c.pty != nil {
+		_, err = c.stdin.Write([]byte{eotCharacter})
+	} else {
+		err = c.stdin.Close()
+	}
+	if err != nil {
+		klog.V(4).InfoS("Failed to close stdin of container", "containerID", c.id, "err", err)
+	}
+}
+
+// getRunningContainer 根据ID查找正在运行的容器实例
+func (r *processRuntime) getRunningContainer(id kubecontainer.ContainerID) (*containerRecord, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	c := r.findContainer(id)
+	if c == nil {
+		return nil, fmt.Errorf("container %q not found", id.ID)
+	}
+	if c.state != kubecontainer.ContainerStateRunning {
+		return nil, fmt.Errorf("container %q is not running", id.ID)
+	}
+	return c, nil
+}
+
+// outputBroadcaster 容器进程的一个输出流：写到日志的同时转发给所有attach到容器上的客户端。
+// 写客户端失败（比如连接已经断开）时移除该客户端，并通过onError通知attach结束
+type outputBroadcaster struct {
+	out io.Writer
+
+	lock    sync.Mutex
+	clients map[*attachedWriter]struct{}
+}
+
+// attachedWriter attach到容器上的一个客户端
+type attachedWriter struct {
+	w       io.Writer
+	onError func()
+}
+
+func newOutputBroadcaster(out io.Writer) *outputBroadcaster {
+	return &outputBroadcaster{
+		out:     out,
+		clients: make(map[*attachedWriter]struct{}),
+	}
+}
+
+func (b *outputBroadcaster) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	for client := range b.clients {
+		if _, err := client.w.Write(p); err != nil {
+			delete(b.clients, client)
+			client.onError()
+		}
+	}
+	b.lock.Unlock()
+	return b.out.Write(p)
+}
+
+// add 添加一个客户端，之后的输出都会写给它
+func (b *outputBroadcaster) add(w io.Writer, onError func()) *attachedWriter {
+	client := &attachedWriter{w: w, onError: onError}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.clients[client] = struct{}{}
+	return client
+}
+
+func (b *outputBroadcaster) remove(client *attachedWriter) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.clients, client)
+}
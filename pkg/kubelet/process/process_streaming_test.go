@@ -0,0 +1,756 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/client-go/util/flowcontrol"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	proberesults "k8s.io/kubernetes/pkg/kubelet/prober/results"
+	"k8s.io/kubernetes/pkg/kubelet/server/portforward"
+	remotecommandserver "k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	testPodUID       = types.UID("streaming-pod")
+	testPodFullName  = "streaming_default"
+	testWaitTimeout  = 10 * time.Second
+	testPollInterval = 50 * time.Millisecond
+)
+
+// fakeRuntimeHelper runs containers with no environment and keeps pod
+// directories under dir.
+type fakeRuntimeHelper struct {
+	dir string
+}
+
+func (h fakeRuntimeHelper) GenerateRunContainerOptions(pod *v1.Pod, container *v1.Container, podIP string, podIPs []string) (*kubecontainer.RunContainerOptions, func(), error) {
+	return &kubecontainer.RunContainerOptions{}, nil, nil
+}
+
+func (h fakeRuntimeHelper) GetPodDNS(pod *v1.Pod) (*runtimeapi.DNSConfig, error) {
+	return nil, nil
+}
+
+func (h fakeRuntimeHelper) GetPodCgroupParent(pod *v1.Pod) string {
+	return ""
+}
+
+func (h fakeRuntimeHelper) GetPodDir(podUID types.UID) string {
+	return path.Join(h.dir, string(podUID))
+}
+
+func (h fakeRuntimeHelper) GeneratePodHostNameAndDomain(pod *v1.Pod) (string, string, error) {
+	return "", "", nil
+}
+
+func (h fakeRuntimeHelper) GetExtraSupplementalGroupsForPod(pod *v1.Pod) []int64 {
+	return nil
+}
+
+// streamingHost resolves pods and container names to the test pod and its
+// containers, the way the kubelet does before handing a stream to the runtime.
+type streamingHost struct {
+	runtime *processRuntime
+}
+
+func (h *streamingHost) containerID(podUID types.UID, containerName string) (kubecontainer.ContainerID, error) {
+	pods, err := h.runtime.GetPods(false)
+	if err != nil {
+		return kubecontainer.ContainerID{}, err
+	}
+	pod := kubecontainer.Pods(pods).FindPod("", podUID)
+	container := pod.FindContainerByName(containerName)
+	if container == nil {
+		return kubecontainer.ContainerID{}, fmt.Errorf("container not found (%q)", containerName)
+	}
+	return container.ID, nil
+}
+
+func (h *streamingHost) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	id, findErr := h.containerID(uid, container)
+	if findErr != nil {
+		return findErr
+	}
+	return h.runtime.ExecInContainer(id, cmd, in, out, err, tty, resize, timeout)
+}
+
+func (h *streamingHost) AttachContainer(name string, uid types.UID, container string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	id, findErr := h.containerID(uid, container)
+	if findErr != nil {
+		return findErr
+	}
+	return h.runtime.AttachContainer(id, in, out, err, tty, resize)
+}
+
+func (h *streamingHost) PortForward(name string, uid types.UID, port int32, stream io.ReadWriteCloser) error {
+	pods, err := h.runtime.GetPods(false)
+	if err != nil {
+		return err
+	}
+	pod := kubecontainer.Pods(pods).FindPod("", uid)
+	if pod.IsEmpty() {
+		return fmt.Errorf("pod not found (%q)", name)
+	}
+	return h.runtime.PortForward(&pod, port, stream)
+}
+
+// newTestPod returns a pod with a container for each streaming scenario:
+// "sleeper" to exec into, "echo" to attach to, and "tty" to attach to with a
+// terminal.
+func newTestPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "streaming", Namespace: "default", UID: testPodUID},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:    "sleeper",
+					Command: []string{"sleep", "1000"},
+				},
+				{
+					Name:    "echo",
+					Command: []string{"sh", "-c", `while read l; do echo "out $l"; echo "err $l" >&2; done`},
+					Stdin:   true,
+				},
+				{
+					Name:    "tty",
+					Command: []string{"sh", "-c", "while read l; do stty size; done"},
+					Stdin:   true,
+					TTY:     true,
+				},
+			},
+		},
+	}
+}
+
+// startTestRuntime starts the containers of newTestPod in a process runtime
+// and returns a server that streams exec, attach and port forward requests to it.
+func startTestRuntime(t *testing.T) (*processRuntime, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(path.Join(dir, string(testPodUID)), 0750); err != nil {
+		t.Fatal(err)
+	}
+	r := NewProcessRuntime(record.NewFakeRecorder(100), fakeRuntimeHelper{dir: dir},
+		proberesults.NewManager(), proberesults.NewManager(), 1<<20, 2).(*processRuntime)
+
+	pod := newTestPod()
+	status, err := r.GetPodStatus(pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := r.SyncPod(pod, status, nil, flowcontrol.NewBackOff(time.Second, time.Minute)); result.Error() != nil {
+		t.Fatalf("failed to start pod: %v", result.Error())
+	}
+	t.Cleanup(func() {
+		if err := r.KillPod(pod, kubecontainer.Pod{ID: pod.UID}, nil); err != nil {
+			t.Errorf("failed to kill pod: %v", err)
+		}
+	})
+
+	host := &streamingHost{runtime: r}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/exec/", func(w http.ResponseWriter, req *http.Request) {
+		opts, err := remotecommandserver.NewOptions(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remotecommandserver.ServeExec(w, req, host, testPodFullName, testPodUID, path.Base(req.URL.Path),
+			req.URL.Query()[v1.ExecCommandParam], opts, time.Minute, remotecommandconsts.DefaultStreamCreationTimeout,
+			remotecommandconsts.SupportedStreamingProtocols)
+	})
+	mux.HandleFunc("/attach/", func(w http.ResponseWriter, req *http.Request) {
+		opts, err := remotecommandserver.NewOptions(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remotecommandserver.ServeAttach(w, req, host, testPodFullName, testPodUID, path.Base(req.URL.Path),
+			opts, time.Minute, remotecommandconsts.DefaultStreamCreationTimeout,
+			remotecommandconsts.SupportedStreamingProtocols)
+	})
+	mux.HandleFunc("/portForward/", func(w http.ResponseWriter, req *http.Request) {
+		opts, err := portforward.NewV4Options(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		portforward.ServePortForward(w, req, host, testPodFullName, testPodUID, opts, time.Minute,
+			remotecommandconsts.DefaultStreamCreationTimeout, portforward.SupportedProtocols)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return r, ts
+}
+
+// streamURL builds an exec or attach URL the way kubectl does.
+func streamURL(ts *httptest.Server, kind, container string, cmd []string, stdin, stdout, stderr, tty bool) *url.URL {
+	query := url.Values{}
+	for _, c := range cmd {
+		query.Add(v1.ExecCommandParam, c)
+	}
+	for param, enabled := range map[string]bool{
+		v1.ExecStdinParam:  stdin,
+		v1.ExecStdoutParam: stdout,
+		v1.ExecStderrParam: stderr,
+		v1.ExecTTYParam:    tty,
+	} {
+		if enabled {
+			query.Set(param, "1")
+		}
+	}
+	u, _ := url.Parse(ts.URL)
+	u.Path = "/" + kind + "/" + container
+	u.RawQuery = query.Encode()
+	return u
+}
+
+// syncBuffer is a bytes.Buffer that can be written and read concurrently.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// sizeQueue hands the terminal sizes sent on it to the executor.
+type sizeQueue chan remotecommand.TerminalSize
+
+func (q sizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// poll calls send until done returns true, failing the test after testWaitTimeout.
+func poll(t *testing.T, what string, send func(), done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testWaitTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		send()
+		time.Sleep(testPollInterval)
+	}
+}
+
+// streamSPDY runs an exec or attach request with client-go's SPDY executor.
+func streamSPDY(t *testing.T, u *url.URL, opts remotecommand.StreamOptions) error {
+	t.Helper()
+	executor, err := remotecommand.NewSPDYExecutor(&restclient.Config{Host: u.Scheme + "://" + u.Host}, "POST", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testWaitTimeout)
+	defer cancel()
+	return executor.StreamWithContext(ctx, opts)
+}
+
+func TestExecSPDY(t *testing.T) {
+	_, ts := startTestRuntime(t)
+
+	t.Run("stdin stdout stderr", func(t *testing.T) {
+		stdout, stderr := &syncBuffer{}, &syncBuffer{}
+		u := streamURL(ts, "exec", "sleeper", []string{"sh", "-c", "cat; echo bar >&2"}, true, true, true, false)
+		err := streamSPDY(t, u, remotecommand.StreamOptions{
+			Stdin:  strings.NewReader("foo"),
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stdout.String() != "foo" {
+			t.Errorf("expected stdout %q, got %q", "foo", stdout.String())
+		}
+		if stderr.String() != "bar\n" {
+			t.Errorf("expected stderr %q, got %q", "bar\n", stderr.String())
+		}
+	})
+
+	t.Run("tty with resize", func(t *testing.T) {
+		stdinReader, stdinWriter := io.Pipe()
+		defer stdinWriter.Close()
+		sizes := make(sizeQueue, 1)
+		defer close(sizes)
+		sizes <- remotecommand.TerminalSize{Width: 100, Height: 30}
+
+		stdout := &syncBuffer{}
+		u := streamURL(ts, "exec", "sleeper", []string{"sh", "-c", `while read l; do [ "$l" = q ] && exit 0; stty size; done`}, true, true, false, true)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- streamSPDY(t, u, remotecommand.StreamOptions{
+				Stdin:             stdinReader,
+				Stdout:            stdout,
+				Tty:               true,
+				TerminalSizeQueue: sizes,
+			})
+		}()
+		poll(t, "the terminal to be resized", func() { stdinWriter.Write([]byte("\n")) },
+			func() bool { return strings.Contains(stdout.String(), "30 100") })
+		stdinWriter.Write([]byte("q\n"))
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("non-zero exit code", func(t *testing.T) {
+		u := streamURL(ts, "exec", "sleeper", []string{"sh", "-c", "exit 3"}, false, true, false, false)
+		err := streamSPDY(t, u, remotecommand.StreamOptions{Stdout: io.Discard})
+		exitErr, ok := err.(utilexec.ExitError)
+		if !ok {
+			t.Fatalf("expected an exit error, got %v", err)
+		}
+		if exitErr.ExitStatus() != 3 {
+			t.Errorf("expected exit code 3, got %d", exitErr.ExitStatus())
+		}
+	})
+
+	t.Run("container not found", func(t *testing.T) {
+		u := streamURL(ts, "exec", "missing", []string{"true"}, false, true, false, false)
+		err := streamSPDY(t, u, remotecommand.StreamOptions{Stdout: io.Discard})
+		if err == nil || !strings.Contains(err.Error(), `container not found ("missing")`) {
+			t.Errorf("expected container not found error, got %v", err)
+		}
+	})
+}
+
+func TestAttachSPDY(t *testing.T) {
+	_, ts := startTestRuntime(t)
+
+	t.Run("stdin stdout stderr", func(t *testing.T) {
+		stdinReader, stdinWriter := io.Pipe()
+		stdout, stderr := &syncBuffer{}, &syncBuffer{}
+		u := streamURL(ts, "attach", "echo", nil, true, true, true, false)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- streamSPDY(t, u, remotecommand.StreamOptions{
+				Stdin:  stdinReader,
+				Stdout: stdout,
+				Stderr: stderr,
+			})
+		}()
+		poll(t, "the container to echo stdin", func() { stdinWriter.Write([]byte("ping\n")) }, func() bool {
+			return strings.Contains(stdout.String(), "out ping\n") && strings.Contains(stderr.String(), "err ping\n")
+		})
+		// Closing stdin detaches the client.
+		stdinWriter.Close()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("tty with resize", func(t *testing.T) {
+		stdinReader, stdinWriter := io.Pipe()
+		sizes := make(sizeQueue, 1)
+		defer close(sizes)
+		sizes <- remotecommand.TerminalSize{Width: 120, Height: 40}
+
+		stdout := &syncBuffer{}
+		u := streamURL(ts, "attach", "tty", nil, true, true, false, true)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- streamSPDY(t, u, remotecommand.StreamOptions{
+				Stdin:             stdinReader,
+				Stdout:            stdout,
+				Tty:               true,
+				TerminalSizeQueue: sizes,
+			})
+		}()
+		poll(t, "the terminal to be resized", func() { stdinWriter.Write([]byte("\n")) },
+			func() bool { return strings.Contains(stdout.String(), "40 120") })
+		stdinWriter.Close()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("container not found", func(t *testing.T) {
+		u := streamURL(ts, "attach", "missing", nil, false, true, false, false)
+		err := streamSPDY(t, u, remotecommand.StreamOptions{Stdout: io.Discard})
+		if err == nil || !strings.Contains(err.Error(), `container not found ("missing")`) {
+			t.Errorf("expected container not found error, got %v", err)
+		}
+	})
+}
+
+// Streams of the WebSocket channel protocol.
+const (
+	wsStdin byte = iota
+	wsStdout
+	wsStderr
+	wsError
+	wsResize
+)
+
+// wsClient speaks the v4 channel protocol that kubectl used over WebSockets:
+// every message starts with the number of the stream it belongs to. Client-go
+// of this version has no WebSocket executor, so the tests drive the protocol
+// directly.
+type wsClient struct {
+	conn    *websocket.Conn
+	streams [5]syncBuffer
+	// done is closed once the server closes the connection.
+	done chan struct{}
+}
+
+func dialWebSocket(t *testing.T, u *url.URL) *wsClient {
+	t.Helper()
+	wsURL := *u
+	wsURL.Scheme = "ws"
+	config, err := websocket.NewConfig(wsURL.String(), "http://"+u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Protocol = []string{"v4.channel.k8s.io"}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", wsURL.String(), err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &wsClient{conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		for {
+			var msg []byte
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+			if len(msg) > 0 && int(msg[0]) < len(c.streams) {
+				c.streams[msg[0]].Write(msg[1:])
+			}
+		}
+	}()
+	return c
+}
+
+func (c *wsClient) send(t *testing.T, stream byte, data []byte) {
+	if err := websocket.Message.Send(c.conn, append([]byte{stream}, data...)); err != nil {
+		t.Errorf("failed to write to stream %d: %v", stream, err)
+	}
+}
+
+// status waits for the server to close the connection and returns the status
+// it wrote to the error stream.
+func (c *wsClient) status(t *testing.T) *metav1.Status {
+	t.Helper()
+	select {
+	case <-c.done:
+	case <-time.After(testWaitTimeout):
+		t.Fatal("timed out waiting for the server to close the connection")
+	}
+	status := &metav1.Status{}
+	if err := json.Unmarshal([]byte(c.streams[wsError].String()), status); err != nil {
+		t.Fatalf("failed to decode status %q: %v", c.streams[wsError].String(), err)
+	}
+	return status
+}
+
+func TestExecWebSocket(t *testing.T) {
+	_, ts := startTestRuntime(t)
+
+	t.Run("stdin stdout stderr", func(t *testing.T) {
+		u := streamURL(ts, "exec", "sleeper", []string{"sh", "-c", "head -n 1; echo bar >&2"}, true, true, true, false)
+		c := dialWebSocket(t, u)
+		c.send(t, wsStdin, []byte("foo\n"))
+		if status := c.status(t); status.Status != metav1.StatusSuccess {
+			t.Fatalf("expected success, got %+v", status)
+		}
+		if got := c.streams[wsStdout].String(); got != "foo\n" {
+			t.Errorf("expected stdout %q, got %q", "foo\n", got)
+		}
+		if got := c.streams[wsStderr].String(); got != "bar\n" {
+			t.Errorf("expected stderr %q, got %q", "bar\n", got)
+		}
+	})
+
+	t.Run("tty with resize", func(t *testing.T) {
+		u := streamURL(ts, "exec", "sleeper", []string{"sh", "-c", `while read l; do [ "$l" = q ] && exit 0; stty size; done`}, true, true, false, true)
+		c := dialWebSocket(t, u)
+		size, _ := json.Marshal(remotecommand.TerminalSize{Width: 100, Height: 30})
+		c.send(t, wsResize, size)
+		poll(t, "the terminal to be resized", func() { c.send(t, wsStdin, []byte("\n")) },
+			func() bool { return strings.Contains(c.streams[wsStdout].String(), "30 100") })
+		c.send(t, wsStdin, []byte("q\n"))
+		if status := c.status(t); status.Status != metav1.StatusSuccess {
+			t.Fatalf("expected success, got %+v", status)
+		}
+	})
+
+	t.Run("non-zero exit code", func(t *testing.T) {
+		u := streamURL(ts, "exec", "sleeper", []string{"sh", "-c", "exit 3"}, false, true, false, false)
+		status := dialWebSocket(t, u).status(t)
+		if status.Status != metav1.StatusFailure || status.Reason != remotecommandconsts.NonZeroExitCodeReason {
+			t.Fatalf("expected a non-zero exit code failure, got %+v", status)
+		}
+		if status.Details == nil || len(status.Details.Causes) != 1 || status.Details.Causes[0].Message != "3" {
+			t.Errorf("expected exit code 3 in the status details, got %+v", status.Details)
+		}
+	})
+
+	t.Run("container not found", func(t *testing.T) {
+		u := streamURL(ts, "exec", "missing", []string{"true"}, false, true, false, false)
+		status := dialWebSocket(t, u).status(t)
+		if status.Status != metav1.StatusFailure || !strings.Contains(status.Message, `container not found ("missing")`) {
+			t.Errorf("expected container not found failure, got %+v", status)
+		}
+	})
+}
+
+func TestAttachWebSocket(t *testing.T) {
+	_, ts := startTestRuntime(t)
+
+	t.Run("stdin stdout stderr", func(t *testing.T) {
+		u := streamURL(ts, "attach", "echo", nil, true, true, true, false)
+		c := dialWebSocket(t, u)
+		poll(t, "the container to echo stdin", func() { c.send(t, wsStdin, []byte("ping\n")) }, func() bool {
+			return strings.Contains(c.streams[wsStdout].String(), "out ping\n") &&
+				strings.Contains(c.streams[wsStderr].String(), "err ping\n")
+		})
+	})
+
+	t.Run("tty with resize", func(t *testing.T) {
+		u := streamURL(ts, "attach", "tty", nil, true, true, false, true)
+		c := dialWebSocket(t, u)
+		size, _ := json.Marshal(remotecommand.TerminalSize{Width: 120, Height: 40})
+		c.send(t, wsResize, size)
+		poll(t, "the terminal to be resized", func() { c.send(t, wsStdin, []byte("\n")) },
+			func() bool { return strings.Contains(c.streams[wsStdout].String(), "40 120") })
+	})
+
+	t.Run("container not found", func(t *testing.T) {
+		u := streamURL(ts, "attach", "missing", nil, false, true, false, false)
+		status := dialWebSocket(t, u).status(t)
+		if status.Status != metav1.StatusFailure || !strings.Contains(status.Message, `container not found ("missing")`) {
+			t.Errorf("expected container not found failure, got %+v", status)
+		}
+	})
+}
+
+// startEchoServer listens on a loopback port and writes back everything it
+// reads on each connection, closing the connection once the client stops
+// writing.
+func startEchoServer(t *testing.T) int32 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+// closedPort returns a loopback port nothing listens on.
+func closedPort(t *testing.T) int32 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := int32(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+	return port
+}
+
+// dialPortForwardSPDY opens a SPDY port forward connection to the test pod.
+func dialPortForwardSPDY(t *testing.T, ts *httptest.Server) httpstream.Connection {
+	t.Helper()
+	transport, upgrader, err := spdy.RoundTripperFor(&restclient.Config{Host: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(ts.URL + "/portForward/streaming")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", u)
+	conn, protocol, err := dialer.Dial(portforward.ProtocolV1Name)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if protocol != portforward.ProtocolV1Name {
+		t.Fatalf("expected protocol %q, got %q", portforward.ProtocolV1Name, protocol)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// createPortForwardStreams creates the error and data streams of one port
+// forward request, the way client-go's port forwarder does.
+func createPortForwardStreams(t *testing.T, conn httpstream.Connection, port int32, requestID int) (httpstream.Stream, httpstream.Stream) {
+	t.Helper()
+	headers := http.Header{}
+	headers.Set(v1.StreamType, v1.StreamTypeError)
+	headers.Set(v1.PortHeader, strconv.Itoa(int(port)))
+	headers.Set(v1.PortForwardRequestIDHeader, strconv.Itoa(requestID))
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		t.Fatalf("failed to create error stream: %v", err)
+	}
+	// The client never writes to the error stream.
+	errorStream.Close()
+
+	headers.Set(v1.StreamType, v1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		t.Fatalf("failed to create data stream: %v", err)
+	}
+	return errorStream, dataStream
+}
+
+func TestPortForwardSPDY(t *testing.T) {
+	_, ts := startTestRuntime(t)
+
+	t.Run("concurrent streams", func(t *testing.T) {
+		port := startEchoServer(t)
+		conn := dialPortForwardSPDY(t, ts)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			errorStream, dataStream := createPortForwardStreams(t, conn, port, i)
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				message := fmt.Sprintf("hello from stream %d", i)
+				if _, err := dataStream.Write([]byte(message)); err != nil {
+					t.Errorf("stream %d: failed to write: %v", i, err)
+					return
+				}
+				// Closing the data stream ends the request once the echo is read back.
+				dataStream.Close()
+				data, err := io.ReadAll(dataStream)
+				if err != nil {
+					t.Errorf("stream %d: failed to read: %v", i, err)
+				}
+				if string(data) != message {
+					t.Errorf("stream %d: expected %q, got %q", i, message, string(data))
+				}
+				if errData, _ := io.ReadAll(errorStream); len(errData) != 0 {
+					t.Errorf("stream %d: unexpected error: %s", i, errData)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("closed port", func(t *testing.T) {
+		port := closedPort(t)
+		conn := dialPortForwardSPDY(t, ts)
+		errorStream, dataStream := createPortForwardStreams(t, conn, port, 0)
+		defer dataStream.Close()
+
+		errData, err := io.ReadAll(errorStream)
+		if err != nil {
+			t.Fatalf("failed to read error stream: %v", err)
+		}
+		expected := fmt.Sprintf("error forwarding port %d to pod %s, uid %s: failed to connect to port %d", port, testPodFullName, testPodUID, port)
+		if !strings.HasPrefix(string(errData), expected) {
+			t.Errorf("expected error starting with %q, got %q", expected, string(errData))
+		}
+	})
+}
+
+// portForwardWebSocketURL builds a WebSocket port forward URL for ports.
+func portForwardWebSocketURL(ts *httptest.Server, ports ...int32) *url.URL {
+	query := url.Values{}
+	for _, port := range ports {
+		query.Add(v1.PortHeader, strconv.Itoa(int(port)))
+	}
+	u, _ := url.Parse(ts.URL + "/portForward/streaming")
+	u.RawQuery = query.Encode()
+	return u
+}
+
+// portPrefix is what the server writes first on both streams of a port.
+func portPrefix(port int32) string {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(port))
+	return string(b)
+}
+
+func TestPortForwardWebSocket(t *testing.T) {
+	_, ts := startTestRuntime(t)
+
+	t.Run("concurrent streams", func(t *testing.T) {
+		port := startEchoServer(t)
+		// Each port gets a data and an error channel: 0 and 1 for the
+		// first, 2 and 3 for the second.
+		c := dialWebSocket(t, portForwardWebSocketURL(ts, port, port))
+		c.send(t, 0, []byte("hello from stream 0"))
+		c.send(t, 2, []byte("hello from stream 1"))
+		poll(t, "both streams to be echoed", func() {}, func() bool {
+			return c.streams[0].String() == portPrefix(port)+"hello from stream 0" &&
+				c.streams[2].String() == portPrefix(port)+"hello from stream 1"
+		})
+		for _, channel := range []int{1, 3} {
+			if got := c.streams[channel].String(); got != portPrefix(port) {
+				t.Errorf("unexpected data on error channel %d: %q", channel, got)
+			}
+		}
+	})
+
+	t.Run("closed port", func(t *testing.T) {
+		port := closedPort(t)
+		c := dialWebSocket(t, portForwardWebSocketURL(ts, port))
+		select {
+		case <-c.done:
+		case <-time.After(testWaitTimeout):
+			t.Fatal("timed out waiting for the server to close the connection")
+		}
+		expected := portPrefix(port) + fmt.Sprintf("error forwarding port %d to pod %s, uid %s: failed to connect to port %d", port, testPodFullName, testPodUID, port)
+		if got := c.streams[1].String(); !strings.HasPrefix(got, expected) {
+			t.Errorf("expected error starting with %q, got %q", expected, got)
+		}
+	})
+}
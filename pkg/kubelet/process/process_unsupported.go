@@ -0,0 +1,54 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// errTtyNotSupported 非linux平台上不支持为进程分配伪终端
+var errTtyNotSupported = errors.New("tty is not supported by the process runtime on this platform")
+
+func setSysProcAttr(cmd *exec.Cmd) {
+}
+
+func setTtySysProcAttr(cmd *exec.Cmd) {
+}
+
+func openPty() (*os.File, *os.File, error) {
+	return nil, nil, errTtyNotSupported
+}
+
+func setTerminalSize(pty *os.File, size remotecommand.TerminalSize) error {
+	return errTtyNotSupported
+}
+
+// terminateProcessGroup 非linux平台上只能向进程本身发送中断信号
+func terminateProcessGroup(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(os.Interrupt)
+}
+
+// killProcessGroup 非linux平台上只能杀死进程本身
+func killProcessGroup(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+func exitCodeFromState(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	return state.ExitCode()
+}
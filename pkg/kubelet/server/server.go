@@ -0,0 +1,653 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/apiserver/pkg/util/flushwriter"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/component-base/configz"
+	compbasemetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/metrics/collectors"
+	"k8s.io/kubernetes/pkg/kubelet/server/portforward"
+	remotecommandserver "k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+	"k8s.io/kubernetes/pkg/kubelet/server/stats"
+)
+
+const (
+	metricsPath         = "/metrics"
+	metricsResourcePath = metricsPath + "/resource"
+	statsPath           = "/stats/"
+	containerLogsPath   = "/containerLogs"
+
+	// streamingConnectionIdleTimeout is the maximum time a streaming connection
+	// can be idle before the connection is automatically closed. It matches the
+	// default of the kubelet's --streaming-connection-idle-timeout flag.
+	streamingConnectionIdleTimeout = 4 * time.Hour
+)
+
+// Server is a http.Handler which exposes kubelet functionality over HTTP.
+type Server struct {
+	host            HostInterface
+	restfulCont     containerInterface
+	summaryProvider stats.SummaryProvider
+	auth            AuthInterface
+}
+
+// TLSOptions holds the TLS options.
+type TLSOptions struct {
+	Config   *tls.Config
+	CertFile string
+	KeyFile  string
+}
+
+// AuthInterface contains all methods required by the auth filters
+type AuthInterface interface {
+	authenticator.Request
+	NodeRequestAttributesGetter
+	authorizer.Authorizer
+}
+
+// NodeRequestAttributesGetter is an interface that knows how to get attributes for a request.
+type NodeRequestAttributesGetter interface {
+	GetRequestAttributes(u user.Info, r *http.Request) authorizer.Attributes
+}
+
+// containerInterface defines the restful.Container functions used on the root container
+type containerInterface interface {
+	Add(service *restful.WebService) *restful.Container
+	Handle(path string, handler http.Handler)
+	Filter(filter restful.FilterFunction)
+	ServeHTTP(w http.ResponseWriter, r *http.Request)
+	RegisteredWebServices() []*restful.WebService
+
+	// RegisteredHandlePaths returns the paths of handlers registered directly with the container (non-web-services)
+	// Used to test filters are being applied on non-web-service handlers
+	RegisteredHandlePaths() []string
+}
+
+// filteringContainer delegates all Handle(...) calls to Container.HandleWithFilter(...),
+// so we can ensure restful.FilterFunctions are used for all handlers
+type filteringContainer struct {
+	*restful.Container
+
+	registeredHandlePaths []string
+}
+
+func (a *filteringContainer) Handle(path string, handler http.Handler) {
+	a.HandleWithFilter(path, handler)
+	a.registeredHandlePaths = append(a.registeredHandlePaths, path)
+}
+func (a *filteringContainer) RegisteredHandlePaths() []string {
+	return a.registeredHandlePaths
+}
+
+// ListenAndServeKubeletServer initializes a server to respond to HTTP network requests on the Kubelet.
+func ListenAndServeKubeletServer(
+	host HostInterface,
+	summaryProvider stats.SummaryProvider,
+	auth AuthInterface,
+	address net.IP,
+	port uint,
+	tlsOptions *TLSOptions) {
+
+	klog.InfoS("Starting to listen", "address", address, "port", port)
+	handler := NewServer(host, summaryProvider, auth)
+	s := &http.Server{
+		Addr:           net.JoinHostPort(address.String(), strconv.FormatUint(uint64(port), 10)),
+		Handler:        &handler,
+		IdleTimeout:    90 * time.Second, // matches http.DefaultTransport keep-alive timeout
+		ReadTimeout:    4 * 60 * time.Minute,
+		WriteTimeout:   4 * 60 * time.Minute,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	if tlsOptions != nil {
+		s.TLSConfig = tlsOptions.Config
+		// Passing empty strings as the cert and key files means no
+		// cert/keys are specified and GetCertificate in the TLSConfig
+		// should be called instead.
+		if err := s.ListenAndServeTLS(tlsOptions.CertFile, tlsOptions.KeyFile); err != nil {
+			klog.ErrorS(err, "Failed to listen and serve")
+			os.Exit(1)
+		}
+	} else if err := s.ListenAndServe(); err != nil {
+		klog.ErrorS(err, "Failed to listen and serve")
+		os.Exit(1)
+	}
+}
+
+// HostInterface contains all the kubelet methods required by the server.
+// For testability.
+type HostInterface interface {
+	GetPods() []*v1.Pod
+	GetRunningPods() ([]*v1.Pod, error)
+	GetPodByName(namespace, name string) (*v1.Pod, bool)
+	GetKubeletContainerLogs(ctx context.Context, podFullName, containerName string, logOptions *v1.PodLogOptions, stdout, stderr io.Writer) error
+	// Healthz returns an error if the sync loop is blocked, e.g. PLEG has not
+	// relisted for too long.
+	Healthz() error
+	remotecommandserver.Executor
+	remotecommandserver.Attacher
+	portforward.PortForwarder
+}
+
+// NewServer initializes and configures a kubelet.Server object to handle HTTP requests.
+func NewServer(host HostInterface, summaryProvider stats.SummaryProvider, auth AuthInterface) Server {
+	server := Server{
+		host:            host,
+		restfulCont:     &filteringContainer{Container: restful.NewContainer()},
+		summaryProvider: summaryProvider,
+		auth:            auth,
+	}
+	if auth != nil {
+		server.InstallAuthFilter()
+	}
+	server.InstallDefaultHandlers()
+	server.InstallDebuggingHandlers()
+	return server
+}
+
+// InstallAuthFilter installs authentication filters with the restful Container.
+func (s *Server) InstallAuthFilter() {
+	s.restfulCont.Filter(func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		// Authenticate
+		info, ok, err := s.auth.AuthenticateRequest(req.Request)
+		if err != nil {
+			klog.ErrorS(err, "Unable to authenticate the request due to an error")
+			resp.WriteErrorString(http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if !ok {
+			resp.WriteErrorString(http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		// Get authorization attributes
+		attrs := s.auth.GetRequestAttributes(info.User, req.Request)
+
+		// Authorize
+		decision, _, err := s.auth.Authorize(req.Request.Context(), attrs)
+		if err != nil {
+			klog.ErrorS(err, "Authorization error", "user", attrs.GetUser().GetName(), "verb", attrs.GetVerb(), "resource", attrs.GetResource(), "subresource", attrs.GetSubresource())
+			msg := fmt.Sprintf("Authorization error (user=%s, verb=%s, resource=%s, subresource=%s)", attrs.GetUser().GetName(), attrs.GetVerb(), attrs.GetResource(), attrs.GetSubresource())
+			resp.WriteErrorString(http.StatusInternalServerError, msg)
+			return
+		}
+		if decision != authorizer.DecisionAllow {
+			klog.V(2).InfoS("Forbidden", "user", attrs.GetUser().GetName(), "verb", attrs.GetVerb(), "resource", attrs.GetResource(), "subresource", attrs.GetSubresource())
+			msg := fmt.Sprintf("Forbidden (user=%s, verb=%s, resource=%s, subresource=%s)", attrs.GetUser().GetName(), attrs.GetVerb(), attrs.GetResource(), attrs.GetSubresource())
+			resp.WriteErrorString(http.StatusForbidden, msg)
+			return
+		}
+
+		// Continue
+		chain.ProcessFilter(req, resp)
+	})
+}
+
+// InstallDefaultHandlers registers the default set of supported HTTP request
+// patterns with the restful Container.
+func (s *Server) InstallDefaultHandlers() {
+	healthz.InstallHandler(s.restfulCont,
+		healthz.PingHealthz,
+		healthz.LogHealthz,
+		healthz.NamedCheck("syncloop", s.syncLoopHealthCheck),
+	)
+
+	ws := new(restful.WebService)
+	ws.
+		Path("/pods").
+		Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("").
+		To(s.getPods).
+		Operation("getPods"))
+	s.restfulCont.Add(ws)
+
+	s.restfulCont.Add(stats.CreateHandlers(statsPath, s.summaryProvider))
+
+	s.restfulCont.Handle(metricsPath, legacyregistry.Handler())
+
+	resourceRegistry := compbasemetrics.NewKubeRegistry()
+	resourceRegistry.CustomMustRegister(collectors.NewResourceMetricsCollector(s.summaryProvider))
+	s.restfulCont.Handle(metricsResourcePath,
+		compbasemetrics.HandlerFor(resourceRegistry, compbasemetrics.HandlerOpts{ErrorHandling: compbasemetrics.ContinueOnError}),
+	)
+}
+
+// InstallDebuggingHandlers registers the HTTP request patterns that serve logs or run commands/containers
+func (s *Server) InstallDebuggingHandlers() {
+	klog.InfoS("Adding debug handlers to kubelet server")
+
+	ws := new(restful.WebService)
+	ws.
+		Path(containerLogsPath)
+	ws.Route(ws.GET("/{podNamespace}/{podID}/{containerName}").
+		To(s.getContainerLogs).
+		Operation("getContainerLogs"))
+	s.restfulCont.Add(ws)
+
+	ws = new(restful.WebService)
+	ws.
+		Path("/attach")
+	ws.Route(ws.GET("/{podNamespace}/{podID}/{containerName}").
+		To(s.getAttach).
+		Operation("getAttach"))
+	ws.Route(ws.POST("/{podNamespace}/{podID}/{containerName}").
+		To(s.getAttach).
+		Operation("getAttach"))
+	ws.Route(ws.GET("/{podNamespace}/{podID}/{uid}/{containerName}").
+		To(s.getAttach).
+		Operation("getAttach"))
+	ws.Route(ws.POST("/{podNamespace}/{podID}/{uid}/{containerName}").
+		To(s.getAttach).
+		Operation("getAttach"))
+	s.restfulCont.Add(ws)
+
+	ws = new(restful.WebService)
+	ws.
+		Path("/exec")
+	ws.Route(ws.GET("/{podNamespace}/{podID}/{containerName}").
+		To(s.getExec).
+		Operation("getExec"))
+	ws.Route(ws.POST("/{podNamespace}/{podID}/{containerName}").
+		To(s.getExec).
+		Operation("getExec"))
+	ws.Route(ws.GET("/{podNamespace}/{podID}/{uid}/{containerName}").
+		To(s.getExec).
+		Operation("getExec"))
+	ws.Route(ws.POST("/{podNamespace}/{podID}/{uid}/{containerName}").
+		To(s.getExec).
+		Operation("getExec"))
+	s.restfulCont.Add(ws)
+
+	ws = new(restful.WebService)
+	ws.
+		Path("/portForward")
+	ws.Route(ws.GET("/{podNamespace}/{podID}").
+		To(s.getPortForward).
+		Operation("getPortForward"))
+	ws.Route(ws.POST("/{podNamespace}/{podID}").
+		To(s.getPortForward).
+		Operation("getPortForward"))
+	ws.Route(ws.GET("/{podNamespace}/{podID}/{uid}").
+		To(s.getPortForward).
+		Operation("getPortForward"))
+	ws.Route(ws.POST("/{podNamespace}/{podID}/{uid}").
+		To(s.getPortForward).
+		Operation("getPortForward"))
+	s.restfulCont.Add(ws)
+
+	configz.InstallHandler(s.restfulCont)
+
+	ws = new(restful.WebService)
+	ws.
+		Path("/runningpods/").
+		Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("").
+		To(s.getRunningPods).
+		Operation("getRunningPods"))
+	s.restfulCont.Add(ws)
+}
+
+// Checks if kubelet's sync loop that updates containers is working.
+func (s *Server) syncLoopHealthCheck(req *http.Request) error {
+	if err := s.host.Healthz(); err != nil {
+		return fmt.Errorf("sync loop is not healthy: %v", err)
+	}
+	return nil
+}
+
+// getContainerLogs handles containerLogs request against the Kubelet
+func (s *Server) getContainerLogs(request *restful.Request, response *restful.Response) {
+	podNamespace := request.PathParameter("podNamespace")
+	podID := request.PathParameter("podID")
+	containerName := request.PathParameter("containerName")
+	ctx := request.Request.Context()
+
+	if len(podID) == 0 {
+		// TODO: Why return JSON when the rest return plaintext errors?
+		// TODO: Why return plaintext errors?
+		response.WriteError(http.StatusBadRequest, fmt.Errorf(`{"message": "Missing podID."}`))
+		return
+	}
+	if len(containerName) == 0 {
+		// TODO: Why return JSON when the rest return plaintext errors?
+		response.WriteError(http.StatusBadRequest, fmt.Errorf(`{"message": "Missing container name."}`))
+		return
+	}
+	if len(podNamespace) == 0 {
+		// TODO: Why return JSON when the rest return plaintext errors?
+		response.WriteError(http.StatusBadRequest, fmt.Errorf(`{"message": "Missing podNamespace."}`))
+		return
+	}
+
+	query := request.Request.URL.Query()
+	// backwards compatibility for the "tail" query parameter
+	if tail := request.QueryParameter("tail"); len(tail) > 0 {
+		query["tailLines"] = []string{tail}
+		// "all" is the same as omitting tail
+		if tail == "all" {
+			delete(query, "tailLines")
+		}
+	}
+	// container logs on the kubelet are locked to the v1 API version of PodLogOptions
+	logOptions, err := decodePodLogOptions(query)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, fmt.Errorf(`{"message": "Unable to decode query."}`))
+		return
+	}
+	if err := validatePodLogOptions(logOptions); err != nil {
+		response.WriteError(http.StatusUnprocessableEntity, fmt.Errorf(`{"message": "Invalid request."}`))
+		return
+	}
+
+	pod, ok := s.host.GetPodByName(podNamespace, podID)
+	if !ok {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("pod %q does not exist", podID))
+		return
+	}
+	// Check if containerName is valid.
+	if kubecontainer.GetContainerSpec(pod, containerName) == nil {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("container %q not found in pod %q", containerName, podID))
+		return
+	}
+
+	if _, ok := response.ResponseWriter.(http.Flusher); !ok {
+		response.WriteError(http.StatusInternalServerError, fmt.Errorf("unable to convert %v into http.Flusher, cannot show logs", reflect.TypeOf(response)))
+		return
+	}
+	fw := flushwriter.Wrap(response.ResponseWriter)
+	response.Header().Set("Transfer-Encoding", "chunked")
+	if err := s.host.GetKubeletContainerLogs(ctx, kubecontainer.GetPodFullName(pod), containerName, logOptions, fw, fw); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+}
+
+// decodePodLogOptions decodes the query parameters of a containerLogs request.
+// The generated url.Values conversions of the core API are not part of this
+// tree, so the parameters are parsed by hand.
+func decodePodLogOptions(query url.Values) (*v1.PodLogOptions, error) {
+	logOptions := &v1.PodLogOptions{}
+	var err error
+	parseBool := func(name string, value *bool) {
+		if s := query.Get(name); len(s) > 0 && err == nil {
+			*value, err = strconv.ParseBool(s)
+		}
+	}
+	parseInt := func(name string) *int64 {
+		s := query.Get(name)
+		if len(s) == 0 || err != nil {
+			return nil
+		}
+		var i int64
+		if i, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil
+		}
+		return &i
+	}
+
+	logOptions.Container = query.Get("container")
+	parseBool("follow", &logOptions.Follow)
+	parseBool("previous", &logOptions.Previous)
+	parseBool("timestamps", &logOptions.Timestamps)
+	parseBool("insecureSkipTLSVerifyBackend", &logOptions.InsecureSkipTLSVerifyBackend)
+	logOptions.SinceSeconds = parseInt("sinceSeconds")
+	logOptions.TailLines = parseInt("tailLines")
+	logOptions.LimitBytes = parseInt("limitBytes")
+	if s := query.Get("sinceTime"); len(s) > 0 && err == nil {
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339, s); err == nil {
+			sinceTime := metav1.NewTime(t)
+			logOptions.SinceTime = &sinceTime
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return logOptions, nil
+}
+
+// validatePodLogOptions checks the same constraints as the apiserver does for
+// PodLogOptions.
+func validatePodLogOptions(opts *v1.PodLogOptions) error {
+	if opts.TailLines != nil && *opts.TailLines < 0 {
+		return fmt.Errorf("tailLines must be greater than or equal to 0")
+	}
+	if opts.LimitBytes != nil && *opts.LimitBytes < 1 {
+		return fmt.Errorf("limitBytes must be greater than 0")
+	}
+	switch {
+	case opts.SinceSeconds != nil && opts.SinceTime != nil:
+		return fmt.Errorf("at most one of sinceTime or sinceSeconds may be specified")
+	case opts.SinceSeconds != nil:
+		if *opts.SinceSeconds < 1 {
+			return fmt.Errorf("sinceSeconds must be greater than 0")
+		}
+	}
+	return nil
+}
+
+// encodePods creates an v1.PodList object from pods and returns the encoded
+// PodList.
+func encodePods(pods []*v1.Pod) (data []byte, err error) {
+	podList := new(v1.PodList)
+	for _, pod := range pods {
+		podList.Items = append(podList.Items, *pod)
+	}
+	// TODO: Locked to v1, needs to be made generic
+	codec := scheme.Codecs.LegacyCodec(v1.SchemeGroupVersion)
+	return runtime.Encode(codec, podList)
+}
+
+// getPods returns a list of pods bound to the Kubelet and their spec.
+func (s *Server) getPods(request *restful.Request, response *restful.Response) {
+	pods := s.host.GetPods()
+	data, err := encodePods(pods)
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	writeJSONResponse(response, data)
+}
+
+// getRunningPods returns a list of pods running on Kubelet. The list is
+// provided by the container runtime, and is different from the list returned
+// by getPods, which is a set of desired pods to run.
+func (s *Server) getRunningPods(request *restful.Request, response *restful.Response) {
+	pods, err := s.host.GetRunningPods()
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	data, err := encodePods(pods)
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	writeJSONResponse(response, data)
+}
+
+type execRequestParams struct {
+	podNamespace  string
+	podName       string
+	podUID        types.UID
+	containerName string
+	cmd           []string
+}
+
+func getExecRequestParams(req *restful.Request) execRequestParams {
+	return execRequestParams{
+		podNamespace:  req.PathParameter("podNamespace"),
+		podName:       req.PathParameter("podID"),
+		podUID:        types.UID(req.PathParameter("uid")),
+		containerName: req.PathParameter("containerName"),
+		cmd:           req.Request.URL.Query()[v1.ExecCommandParam],
+	}
+}
+
+// getAttach handles requests to attach to a container.
+func (s *Server) getAttach(request *restful.Request, response *restful.Response) {
+	params := getExecRequestParams(request)
+	streamOpts, err := remotecommandserver.NewOptions(request.Request)
+	if err != nil {
+		utilruntime.HandleError(err)
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	pod, ok := s.host.GetPodByName(params.podNamespace, params.podName)
+	if !ok {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("pod does not exist"))
+		return
+	}
+
+	podFullName := kubecontainer.GetPodFullName(pod)
+	remotecommandserver.ServeAttach(response.ResponseWriter,
+		request.Request,
+		s.host,
+		podFullName,
+		params.podUID,
+		params.containerName,
+		streamOpts,
+		streamingConnectionIdleTimeout,
+		remotecommandconsts.DefaultStreamCreationTimeout,
+		remotecommandconsts.SupportedStreamingProtocols)
+}
+
+// getExec handles requests to run a command inside a container.
+func (s *Server) getExec(request *restful.Request, response *restful.Response) {
+	params := getExecRequestParams(request)
+	streamOpts, err := remotecommandserver.NewOptions(request.Request)
+	if err != nil {
+		utilruntime.HandleError(err)
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	if len(params.cmd) == 0 {
+		response.WriteError(http.StatusBadRequest, fmt.Errorf("you must specify a command"))
+		return
+	}
+	pod, ok := s.host.GetPodByName(params.podNamespace, params.podName)
+	if !ok {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("pod does not exist"))
+		return
+	}
+
+	podFullName := kubecontainer.GetPodFullName(pod)
+	remotecommandserver.ServeExec(response.ResponseWriter,
+		request.Request,
+		s.host,
+		podFullName,
+		params.podUID,
+		params.containerName,
+		params.cmd,
+		streamOpts,
+		streamingConnectionIdleTimeout,
+		remotecommandconsts.DefaultStreamCreationTimeout,
+		remotecommandconsts.SupportedStreamingProtocols)
+}
+
+type portForwardRequestParams struct {
+	podNamespace string
+	podName      string
+	podUID       types.UID
+}
+
+func getPortForwardRequestParams(req *restful.Request) portForwardRequestParams {
+	return portForwardRequestParams{
+		podNamespace: req.PathParameter("podNamespace"),
+		podName:      req.PathParameter("podID"),
+		podUID:       types.UID(req.PathParameter("uid")),
+	}
+}
+
+// getPortForward handles a new restful port forward request. It determines the
+// pod name and uid and then calls ServePortForward.
+func (s *Server) getPortForward(request *restful.Request, response *restful.Response) {
+	params := getPortForwardRequestParams(request)
+
+	portForwardOptions, err := portforward.NewV4Options(request.Request)
+	if err != nil {
+		utilruntime.HandleError(err)
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	pod, ok := s.host.GetPodByName(params.podNamespace, params.podName)
+	if !ok {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("pod does not exist"))
+		return
+	}
+	if len(params.podUID) > 0 && pod.UID != params.podUID {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("pod not found"))
+		return
+	}
+
+	portforward.ServePortForward(response.ResponseWriter,
+		request.Request,
+		s.host,
+		kubecontainer.GetPodFullName(pod),
+		params.podUID,
+		portForwardOptions,
+		streamingConnectionIdleTimeout,
+		remotecommandconsts.DefaultStreamCreationTimeout,
+		portforward.SupportedProtocols)
+}
+
+// Derived from go-restful writeJSON.
+func writeJSONResponse(response *restful.Response, data []byte) {
+	if data == nil {
+		response.WriteHeader(http.StatusOK)
+		// do not write a nil representation
+		return
+	}
+	response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+	response.WriteHeader(http.StatusOK)
+	if _, err := response.Write(data); err != nil {
+		klog.ErrorS(err, "Error writing response")
+	}
+}
+
+// ServeHTTP responds to HTTP requests on the Kubelet.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.restfulCont.ServeHTTP(w, req)
+}
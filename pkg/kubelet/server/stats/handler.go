@@ -0,0 +1,125 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// Provider hosts methods required by stats handlers.
+type Provider interface {
+	// The following stats are provided by the container runtime.
+	//
+	// ListPodStats returns the stats of all the running pods, including
+	// the filesystem usage of their logs and volumes.
+	ListPodStats() ([]statsapi.PodStats, error)
+	// ListPodCPUAndMemoryStats returns the CPU and memory stats of all the
+	// running pods.
+	ListPodCPUAndMemoryStats() ([]statsapi.PodStats, error)
+
+	// The following stats are provided by the node.
+	//
+	// GetNodeCPUAndMemoryStats returns the CPU and memory stats of the node.
+	GetNodeCPUAndMemoryStats() (*statsapi.CPUStats, *statsapi.MemoryStats, error)
+	// RootFsStats returns the stats of the node root filesystem, which is
+	// the filesystem holding the kubelet root directory.
+	RootFsStats() (*statsapi.FsStats, error)
+
+	// Helper methods.
+	//
+	// GetNodeName returns the name of the node.
+	GetNodeName() string
+	// GetNodeStartTime returns the time at which the node was booted.
+	GetNodeStartTime() (time.Time, error)
+}
+
+type handler struct {
+	summaryProvider SummaryProvider
+}
+
+// CreateHandlers creates the REST handlers for the stats.
+func CreateHandlers(rootPath string, summaryProvider SummaryProvider) *restful.WebService {
+	h := &handler{summaryProvider}
+
+	ws := &restful.WebService{}
+	ws.Path(rootPath).
+		Produces(restful.MIME_JSON)
+
+	endpoints := []struct {
+		path    string
+		handler restful.RouteFunction
+	}{
+		{"/summary", h.handleSummary},
+	}
+
+	for _, e := range endpoints {
+		for _, method := range []string{"GET", "POST"} {
+			ws.Route(
+				ws.Method(method).
+					Path(e.path).
+					To(e.handler))
+		}
+	}
+
+	return ws
+}
+
+// Handles stats summary requests to /stats/summary
+// If "only_cpu_and_memory" GET param is true then only cpu and memory is returned in response.
+func (h *handler) handleSummary(request *restful.Request, response *restful.Response) {
+	onlyCPUAndMemory := false
+	err := request.Request.ParseForm()
+	if err != nil {
+		handleError(response, "/stats/summary", fmt.Errorf("parse form failed: %w", err))
+		return
+	}
+	if onlyCPUAndMemoryParam, found := request.Request.Form["only_cpu_and_memory"]; found &&
+		len(onlyCPUAndMemoryParam) == 1 && onlyCPUAndMemoryParam[0] == "true" {
+		onlyCPUAndMemory = true
+	}
+	var summary *statsapi.Summary
+	if onlyCPUAndMemory {
+		summary, err = h.summaryProvider.GetCPUAndMemoryStats()
+	} else {
+		summary, err = h.summaryProvider.Get()
+	}
+	if err != nil {
+		handleError(response, "/stats/summary", err)
+	} else {
+		writeResponse(response, summary)
+	}
+}
+
+func writeResponse(response *restful.Response, stats interface{}) {
+	if err := response.WriteAsJson(stats); err != nil {
+		klog.ErrorS(err, "Error writing response")
+	}
+}
+
+// handleError serializes an error object into an HTTP response.
+// request is provided for logging.
+func handleError(response *restful.Response, request string, err error) {
+	msg := fmt.Sprintf("Internal Error: %v", err)
+	klog.ErrorS(err, "HTTP InternalServerError serving", "request", request)
+	response.WriteErrorString(http.StatusInternalServerError, msg)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// SummaryProvider provides summaries of the stats from Kubelet.
+type SummaryProvider interface {
+	// Get provides a new Summary with the stats from Kubelet.
+	Get() (*statsapi.Summary, error)
+	// GetCPUAndMemoryStats provides a new Summary with the CPU and memory stats from Kubelet,
+	GetCPUAndMemoryStats() (*statsapi.Summary, error)
+}
+
+// summaryProviderImpl implements the SummaryProvider interface.
+type summaryProviderImpl struct {
+	// systemBootTime is the time at which the system was started
+	systemBootTime metav1.Time
+
+	provider Provider
+}
+
+var _ SummaryProvider = &summaryProviderImpl{}
+
+// NewSummaryProvider returns a SummaryProvider using the stats provided by the
+// specified statsProvider.
+func NewSummaryProvider(statsProvider Provider) SummaryProvider {
+	bootTime, err := statsProvider.GetNodeStartTime()
+	if err != nil {
+		// bootTime will be zero if we encounter an error getting the boot time.
+		klog.InfoS("Error getting system boot time. Node metrics will have an incorrect start time", "err", err)
+	}
+
+	return &summaryProviderImpl{
+		systemBootTime: metav1.NewTime(bootTime),
+		provider:       statsProvider,
+	}
+}
+
+func (sp *summaryProviderImpl) Get() (*statsapi.Summary, error) {
+	nodeCPU, nodeMemory, err := sp.provider.GetNodeCPUAndMemoryStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node cpu and memory stats: %v", err)
+	}
+	rootFsStats, err := sp.provider.RootFsStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rootFs stats: %v", err)
+	}
+	podStats, err := sp.provider.ListPodStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod stats: %v", err)
+	}
+
+	nodeStats := statsapi.NodeStats{
+		NodeName:  sp.provider.GetNodeName(),
+		CPU:       nodeCPU,
+		Memory:    nodeMemory,
+		StartTime: sp.systemBootTime,
+		Fs:        rootFsStats,
+	}
+	summary := statsapi.Summary{
+		Node: nodeStats,
+		Pods: podStats,
+	}
+	return &summary, nil
+}
+
+func (sp *summaryProviderImpl) GetCPUAndMemoryStats() (*statsapi.Summary, error) {
+	nodeCPU, nodeMemory, err := sp.provider.GetNodeCPUAndMemoryStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node cpu and memory stats: %v", err)
+	}
+	podStats, err := sp.provider.ListPodCPUAndMemoryStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod stats: %v", err)
+	}
+
+	nodeStats := statsapi.NodeStats{
+		NodeName:  sp.provider.GetNodeName(),
+		CPU:       nodeCPU,
+		Memory:    nodeMemory,
+		StartTime: sp.systemBootTime,
+	}
+	summary := statsapi.Summary{
+		Node: nodeStats,
+		Pods: podStats,
+	}
+	return &summary, nil
+}
@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/procfs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"k8s.io/kubernetes/pkg/volume/util/fs"
+)
+
+// GetNodeCPUAndMemoryStats 从/proc/stat和/proc/meminfo统计节点的CPU和内存。
+// CPU使用时间不包括idle和iowait；内存使用量为总内存减去空闲内存，工作集再减去不活跃的文件缓存，与cAdvisor的算法一致
+func (p *Provider) GetNodeCPUAndMemoryStats() (*statsapi.CPUStats, *statsapi.MemoryStats, error) {
+	procFS, err := procfs.NewDefaultFS()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open procfs: %v", err)
+	}
+	stat, err := procFS.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read /proc/stat: %v", err)
+	}
+	meminfo, err := procFS.Meminfo()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read /proc/meminfo: %v", err)
+	}
+	if meminfo.MemTotal == nil || meminfo.MemFree == nil {
+		return nil, nil, fmt.Errorf("MemTotal or MemFree not found in /proc/meminfo")
+	}
+	now := metav1.Now()
+
+	total := stat.CPUTotal
+	usageCoreNanoSeconds := uint64((total.User + total.Nice + total.System + total.IRQ + total.SoftIRQ + total.Steal) * float64(time.Second))
+	cpu := &statsapi.CPUStats{
+		Time:                 now,
+		UsageCoreNanoSeconds: &usageCoreNanoSeconds,
+	}
+	p.updateNodeCPUNanoCoreUsage(cpu)
+
+	// /proc/meminfo中的单位是kB
+	usageBytes := (*meminfo.MemTotal - *meminfo.MemFree) * 1024
+	workingSetBytes := usageBytes
+	if meminfo.InactiveFile != nil {
+		if inactiveFile := *meminfo.InactiveFile * 1024; inactiveFile < workingSetBytes {
+			workingSetBytes -= inactiveFile
+		} else {
+			workingSetBytes = 0
+		}
+	}
+	availableBytes := *meminfo.MemFree * 1024
+	if meminfo.MemAvailable != nil {
+		availableBytes = *meminfo.MemAvailable * 1024
+	}
+	memory := &statsapi.MemoryStats{
+		Time:            now,
+		AvailableBytes:  &availableBytes,
+		UsageBytes:      &usageBytes,
+		WorkingSetBytes: &workingSetBytes,
+	}
+	return cpu, memory, nil
+}
+
+// updateNodeCPUNanoCoreUsage 根据上一次统计的结果计算节点的UsageNanoCores
+func (p *Provider) updateNodeCPUNanoCoreUsage(cpu *statsapi.CPUStats) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	last := p.lastNodeCPU
+	if last != nil && cpu.Time.After(last.Time.Time) && *cpu.UsageCoreNanoSeconds >= *last.UsageCoreNanoSeconds {
+		nanoCores := uint64(float64(*cpu.UsageCoreNanoSeconds-*last.UsageCoreNanoSeconds) / cpu.Time.Sub(last.Time.Time).Seconds())
+		cpu.UsageNanoCores = &nanoCores
+	}
+	p.lastNodeCPU = cpu
+}
+
+// RootFsStats 统计kubelet根目录所在的文件系统
+func (p *Provider) RootFsStats() (*statsapi.FsStats, error) {
+	available, capacity, usage, inodes, inodesFree, inodesUsed, err := fs.Info(p.rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get filesystem info of %s: %v", p.rootDir, err)
+	}
+	availableBytes := uint64(available)
+	capacityBytes := uint64(capacity)
+	usedBytes := uint64(usage)
+	inodesTotal := uint64(inodes)
+	inodesFreeCount := uint64(inodesFree)
+	inodesUsedCount := uint64(inodesUsed)
+	return &statsapi.FsStats{
+		Time:           metav1.Now(),
+		AvailableBytes: &availableBytes,
+		CapacityBytes:  &capacityBytes,
+		UsedBytes:      &usedBytes,
+		InodesFree:     &inodesFreeCount,
+		Inodes:         &inodesTotal,
+		InodesUsed:     &inodesUsedCount,
+	}, nil
+}
+
+// GetNodeStartTime 节点的启动时间，来自/proc/stat中的btime
+func (p *Provider) GetNodeStartTime() (time.Time, error) {
+	procFS, err := procfs.NewDefaultFS()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to open procfs: %v", err)
+	}
+	stat, err := procFS.Stat()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read /proc/stat: %v", err)
+	}
+	return time.Unix(int64(stat.BootTime), 0), nil
+}
@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"k8s.io/kubernetes/pkg/kubelet/process"
+	serverstats "k8s.io/kubernetes/pkg/kubelet/server/stats"
+	"k8s.io/kubernetes/pkg/kubelet/volumemanager"
+	"k8s.io/kubernetes/pkg/volume/util/fs"
+)
+
+// PodStatsLister 提供运行中的pod及其容器的CPU和内存统计，由运行时实现
+type PodStatsLister interface {
+	ListPodStats() ([]statsapi.PodStats, error)
+}
+
+// Provider 提供/stats/summary需要的统计：pod和容器的CPU、内存来自运行时，
+// 容器日志目录、pod卷目录的磁盘使用以及节点的CPU、内存和根文件系统的使用情况在这里统计
+type Provider struct {
+	nodeName      string
+	rootDir       string
+	runtime       PodStatsLister
+	volumeManager volumemanager.VolumeManager
+
+	// lock 保护lastNodeCPU，lastNodeCPU 上一次统计节点CPU的结果，用于计算UsageNanoCores
+	lock        sync.Mutex
+	lastNodeCPU *statsapi.CPUStats
+}
+
+var _ serverstats.Provider = &Provider{}
+
+// NewProvider 创建统计提供者，rootDir为kubelet的根目录，pod的日志和卷都在这个目录所在的文件系统上
+func NewProvider(nodeName, rootDir string, runtime PodStatsLister, volumeManager volumemanager.VolumeManager) *Provider {
+	return &Provider{
+		nodeName:      nodeName,
+		rootDir:       rootDir,
+		runtime:       runtime,
+		volumeManager: volumeManager,
+	}
+}
+
+func (p *Provider) GetNodeName() string {
+	return p.nodeName
+}
+
+func (p *Provider) ListPodCPUAndMemoryStats() ([]statsapi.PodStats, error) {
+	return p.runtime.ListPodStats()
+}
+
+// ListPodStats 在运行时的统计上补充容器日志、pod卷和pod临时存储的磁盘使用。
+// 日志和卷都是根文件系统上的目录，容量和可用空间使用根文件系统的，使用量按目录统计
+func (p *Provider) ListPodStats() ([]statsapi.PodStats, error) {
+	pods, err := p.runtime.ListPodStats()
+	if err != nil {
+		return nil, err
+	}
+	rootFs, err := p.RootFsStats()
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods {
+		p.addPodFsStats(&pods[i], rootFs)
+	}
+	return pods, nil
+}
+
+// addPodFsStats 统计pod中容器的日志目录和pod的卷目录，两者之和作为pod的临时存储使用量
+func (p *Provider) addPodFsStats(pod *statsapi.PodStats, rootFs *statsapi.FsStats) {
+	podUID := types.UID(pod.PodRef.UID)
+	podDir := p.volumeManager.GetPodDir(podUID)
+	var ephemeral fs.UsageInfo
+
+	for i := range pod.Containers {
+		c := &pod.Containers[i]
+		usage, err := fs.DiskUsage(process.ContainerLogDir(podDir, c.Name))
+		if err != nil {
+			klog.V(4).InfoS("Failed to get disk usage of container logs", "pod", klog.KRef(pod.PodRef.Namespace, pod.PodRef.Name), "containerName", c.Name, "err", err)
+			continue
+		}
+		c.Logs = fsStats(rootFs, usage)
+		ephemeral.Bytes += usage.Bytes
+		ephemeral.Inodes += usage.Inodes
+	}
+
+	volumes := p.volumeManager.GetMountedVolumesForPod(podUID)
+	names := make([]string, 0, len(volumes))
+	for name := range volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := volumes[name]
+		// hostPath卷直接使用宿主机上的目录，不属于pod的临时存储
+		if !strings.HasPrefix(path, podDir+string(filepath.Separator)) {
+			continue
+		}
+		usage, err := fs.DiskUsage(path)
+		if err != nil {
+			klog.V(4).InfoS("Failed to get disk usage of volume", "pod", klog.KRef(pod.PodRef.Namespace, pod.PodRef.Name), "volumeName", name, "err", err)
+			continue
+		}
+		pod.VolumeStats = append(pod.VolumeStats, statsapi.VolumeStats{
+			Name:    name,
+			FsStats: *fsStats(rootFs, usage),
+		})
+		ephemeral.Bytes += usage.Bytes
+		ephemeral.Inodes += usage.Inodes
+	}
+
+	pod.EphemeralStorage = fsStats(rootFs, ephemeral)
+}
+
+// fsStats 根文件系统上一个目录的统计：容量和可用空间是文件系统的，使用量是目录的
+func fsStats(rootFs *statsapi.FsStats, usage fs.UsageInfo) *statsapi.FsStats {
+	usedBytes := uint64(usage.Bytes)
+	inodesUsed := uint64(usage.Inodes)
+	return &statsapi.FsStats{
+		Time:           rootFs.Time,
+		AvailableBytes: rootFs.AvailableBytes,
+		CapacityBytes:  rootFs.CapacityBytes,
+		UsedBytes:      &usedBytes,
+		InodesFree:     rootFs.InodesFree,
+		Inodes:         rootFs.Inodes,
+		InodesUsed:     &inodesUsed,
+	}
+}
@@ -0,0 +1,86 @@
+package volumemanager
+
+import (
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	volumeutil "k8s.io/kubernetes/pkg/volume/util"
+)
+
+// setUpProjected 把projected卷的所有来源写到同一个卷目录中。
+// apiserver为pod注入的kube-api-access-*卷就是projected卷：serviceAccountToken来源写token，
+// configMap来源写kube-root-ca.crt中的ca.crt，downwardAPI来源写namespace。
+// 每次同步pod以及Run的每个周期都会重新生成，token由token manager缓存，超过有效期的80%时才重新申请
+func (vm *volumeManager) setUpProjected(pod *v1.Pod, volume *v1.Volume) (string, error) {
+	source := volume.Projected
+	dir := vm.getPodVolumeDir(pod.UID, projectedPluginName, volume.Name)
+	mode := defaultMode(source.DefaultMode, v1.ProjectedVolumeSourceDefaultMode)
+
+	payload := make(map[string]volumeutil.FileProjection)
+	var errs []error
+	for _, projection := range source.Sources {
+		var data map[string]volumeutil.FileProjection
+		var err error
+		switch {
+		case projection.Secret != nil:
+			optional := projection.Secret.Optional != nil && *projection.Secret.Optional
+			var secret *v1.Secret
+			if secret, err = vm.getSecret(pod.Namespace, projection.Secret.Name, optional); err == nil {
+				data, err = makeSecretPayload(projection.Secret.Items, secret, mode, optional)
+			}
+		case projection.ConfigMap != nil:
+			optional := projection.ConfigMap.Optional != nil && *projection.ConfigMap.Optional
+			var configMap *v1.ConfigMap
+			if configMap, err = vm.getConfigMap(pod.Namespace, projection.ConfigMap.Name, optional); err == nil {
+				data, err = makeConfigMapPayload(projection.ConfigMap.Items, configMap, mode, optional)
+			}
+		case projection.DownwardAPI != nil:
+			data, err = makeDownwardAPIPayload(pod, projection.DownwardAPI.Items, mode)
+		case projection.ServiceAccountToken != nil:
+			data, err = vm.makeServiceAccountTokenPayload(pod, projection.ServiceAccountToken, mode)
+		default:
+			err = fmt.Errorf("projected volume source is not supported by the process runtime")
+		}
+		if err != nil {
+			klog.ErrorS(err, "Unable to collect data for projected volume", "pod", klog.KObj(pod), "volumeName", volume.Name)
+			errs = append(errs, err)
+			continue
+		}
+		for path, file := range data {
+			payload[path] = file
+		}
+	}
+	if len(errs) > 0 {
+		return "", utilerrors.NewAggregate(errs)
+	}
+	return dir, writePayload(dir, fmt.Sprintf("projected volume %s for pod %s/%s", volume.Name, pod.Namespace, pod.Name), payload)
+}
+
+// makeServiceAccountTokenPayload 通过TokenRequest为pod的service account申请绑定到pod的token
+func (vm *volumeManager) makeServiceAccountTokenPayload(pod *v1.Pod, source *v1.ServiceAccountTokenProjection, mode int32) (map[string]volumeutil.FileProjection, error) {
+	var audiences []string
+	if len(source.Audience) != 0 {
+		audiences = []string{source.Audience}
+	}
+	tr, err := vm.tokenManager.GetServiceAccountToken(pod.Namespace, pod.Spec.ServiceAccountName, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: source.ExpirationSeconds,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]volumeutil.FileProjection{
+		source.Path: {Data: []byte(tr.Status.Token), Mode: mode},
+	}, nil
+}
@@ -0,0 +1,298 @@
+package volumemanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/kubelet/config"
+	"k8s.io/kubernetes/pkg/kubelet/configmap"
+	"k8s.io/kubernetes/pkg/kubelet/secret"
+	"k8s.io/kubernetes/pkg/kubelet/token"
+	"k8s.io/kubernetes/pkg/volume/util/fs"
+	"k8s.io/utils/keymutex"
+)
+
+// 卷在pod目录中的插件目录名，与官方kubelet保持一致
+const (
+	emptyDirPluginName    = "kubernetes.io~empty-dir"
+	configMapPluginName   = "kubernetes.io~configmap"
+	secretPluginName      = "kubernetes.io~secret"
+	downwardAPIPluginName = "kubernetes.io~downward-api"
+	projectedPluginName   = "kubernetes.io~projected"
+)
+
+// tokenRefreshPeriod 检查projected卷中的serviceAccountToken是否需要刷新的周期。
+// token的有效期最短为10分钟，token manager在剩余不到20%时重新申请，1分钟的周期能保证token在过期前被替换
+const tokenRefreshPeriod = time.Minute
+
+// VolumeManager 为pod准备卷，并在pod终止后清理。
+// 卷都是宿主机上的目录：emptyDir、configMap、secret、downwardAPI、projected位于<root>/pods/<uid>/volumes/<插件名>/<卷名>，
+// hostPath直接使用宿主机上的路径
+type VolumeManager interface {
+	// MountVolumesForPod 为pod准备pod.Spec.Volumes中的所有卷。已经准备好的emptyDir和hostPath不会重复处理，
+	// configMap、secret、downwardAPI和projected卷每次都会按最新的内容原子地刷新
+	MountVolumesForPod(pod *v1.Pod) error
+	// GetMountedVolumesForPod 返回pod已经准备好的卷，key为卷名，value为宿主机上的路径
+	GetMountedVolumesForPod(podUID types.UID) map[string]string
+	// UnmountVolumesForPod 清理pod的所有卷和容器的根目录，hostPath卷中的内容不会被删除。
+	// pod目录中容器的日志会被保留，已经终止的pod还能读取日志
+	UnmountVolumesForPod(podUID types.UID) error
+	// RemovePodDir 清理pod的所有卷并删除整个pod目录，包括容器的日志，用于已经被删除的pod
+	RemovePodDir(podUID types.UID) error
+	// GetPodDir 返回pod在宿主机上的目录
+	GetPodDir(podUID types.UID) string
+	// ListPodsOnDisk 返回宿主机上存在目录的pod，用于清理kubelet重启前遗留的目录
+	ListPodsOnDisk() ([]types.UID, error)
+	// EmptyDirLimitExceeded 检查pod的emptyDir是否超过了sizeLimit，超过时返回给用户看的信息
+	EmptyDirLimitExceeded(pod *v1.Pod) (string, bool)
+	// Run 定期刷新已经准备好的projected卷，使其中的serviceAccountToken不依赖pod的同步也能在过期前更新，直到stopCh关闭
+	Run(stopCh <-chan struct{})
+}
+
+type volumeManager struct {
+	// rootDir kubelet的根目录
+	rootDir          string
+	secretManager    secret.Manager
+	configMapManager configmap.Manager
+	// tokenManager 为projected卷中的serviceAccountToken申请token
+	tokenManager *token.Manager
+
+	// podLocks 按pod UID串行化同一个pod的卷的准备、刷新和清理。准备卷时要从API server获取内容，
+	// 期间只持有pod的锁，不影响其他pod的卷和对lock的读取
+	podLocks keymutex.KeyMutex
+
+	// lock 保护mountedVolumes和pods
+	lock sync.RWMutex
+	// mountedVolumes pod UID -> 卷名 -> 宿主机路径
+	mountedVolumes map[types.UID]map[string]string
+	// pods 最近一次准备卷时的pod，用于定期刷新projected卷
+	pods map[types.UID]*v1.Pod
+}
+
+var _ VolumeManager = &volumeManager{}
+
+// NewVolumeManager 创建卷管理器，configMap和secret卷的内容通过对应的manager获取，service account token通过tokenManager获取
+func NewVolumeManager(rootDir string, secretManager secret.Manager, configMapManager configmap.Manager,
+	tokenManager *token.Manager) VolumeManager {
+	return &volumeManager{
+		rootDir:          rootDir,
+		secretManager:    secretManager,
+		configMapManager: configMapManager,
+		tokenManager:     tokenManager,
+		podLocks:         keymutex.NewHashed(0),
+		mountedVolumes:   make(map[types.UID]map[string]string),
+		pods:             make(map[types.UID]*v1.Pod),
+	}
+}
+
+// getPodsDir 所有pod目录的父目录
+func (vm *volumeManager) getPodsDir() string {
+	return filepath.Join(vm.rootDir, config.DefaultKubeletPodsDirName)
+}
+
+func (vm *volumeManager) GetPodDir(podUID types.UID) string {
+	return filepath.Join(vm.getPodsDir(), string(podUID))
+}
+
+// getPodVolumeDir 卷在pod目录中的路径
+func (vm *volumeManager) getPodVolumeDir(podUID types.UID, pluginName, volumeName string) string {
+	return filepath.Join(vm.GetPodDir(podUID), config.DefaultKubeletVolumesDirName, pluginName, volumeName)
+}
+
+func (vm *volumeManager) MountVolumesForPod(pod *v1.Pod) error {
+	vm.podLocks.LockKey(string(pod.UID))
+	defer vm.podLocks.UnlockKey(string(pod.UID))
+
+	// 持有lock时只确定需要准备的卷，获取内容和写文件时不持有lock
+	vm.lock.Lock()
+	vm.pods[pod.UID] = pod
+	mounted := vm.mountedVolumes[pod.UID]
+	var pending []*v1.Volume
+	for i := range pod.Spec.Volumes {
+		volume := &pod.Spec.Volumes[i]
+		if _, ok := mounted[volume.Name]; ok && !requiresRemount(volume) {
+			continue
+		}
+		pending = append(pending, volume)
+	}
+	vm.lock.Unlock()
+
+	var errs []error
+	paths := make(map[string]string, len(pending))
+	for _, volume := range pending {
+		path, err := vm.setUpVolume(pod, volume)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("volume %q: %v", volume.Name, err))
+			continue
+		}
+		paths[volume.Name] = path
+	}
+
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+	mounted, ok := vm.mountedVolumes[pod.UID]
+	if !ok {
+		mounted = make(map[string]string)
+		vm.mountedVolumes[pod.UID] = mounted
+	}
+	for name, path := range paths {
+		if _, ok := mounted[name]; !ok {
+			klog.V(3).InfoS("Volume is set up for pod", "pod", klog.KObj(pod), "podUID", pod.UID, "volumeName", name, "path", path)
+			mounted[name] = path
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (vm *volumeManager) GetMountedVolumesForPod(podUID types.UID) map[string]string {
+	vm.lock.RLock()
+	defer vm.lock.RUnlock()
+
+	volumes := make(map[string]string, len(vm.mountedVolumes[podUID]))
+	for name, path := range vm.mountedVolumes[podUID] {
+		volumes[name] = path
+	}
+	return volumes
+}
+
+func (vm *volumeManager) UnmountVolumesForPod(podUID types.UID) error {
+	vm.podLocks.LockKey(string(podUID))
+	defer vm.podLocks.UnlockKey(string(podUID))
+
+	return vm.tearDownVolumes(podUID)
+}
+
+// tearDownVolumes 删除pod的卷和容器的根目录，调用方需要持有pod的锁
+func (vm *volumeManager) tearDownVolumes(podUID types.UID) error {
+	// 卷都是pod目录下的普通目录，容器看到的挂载点也只是容器根目录下的符号链接，
+	// 直接删除这两个目录即可，不会删除hostPath指向的内容。容器的日志不在这两个目录中
+	podDir := vm.GetPodDir(podUID)
+	for _, dir := range []string{config.DefaultKubeletVolumesDirName, config.DefaultKubeletContainersDirName} {
+		if err := os.RemoveAll(filepath.Join(podDir, dir)); err != nil {
+			return fmt.Errorf("failed to remove %s dir of pod %q: %v", dir, podUID, err)
+		}
+	}
+	vm.lock.Lock()
+	delete(vm.mountedVolumes, podUID)
+	delete(vm.pods, podUID)
+	vm.lock.Unlock()
+	vm.tokenManager.DeleteServiceAccountToken(podUID)
+	klog.V(3).InfoS("Volumes are torn down for pod", "podUID", podUID)
+	return nil
+}
+
+func (vm *volumeManager) RemovePodDir(podUID types.UID) error {
+	vm.podLocks.LockKey(string(podUID))
+	defer vm.podLocks.UnlockKey(string(podUID))
+
+	if err := vm.tearDownVolumes(podUID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(vm.GetPodDir(podUID)); err != nil {
+		return fmt.Errorf("failed to remove pod dir of pod %q: %v", podUID, err)
+	}
+	return nil
+}
+
+func (vm *volumeManager) ListPodsOnDisk() ([]types.UID, error) {
+	entries, err := os.ReadDir(vm.getPodsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pods []types.UID
+	for _, entry := range entries {
+		if entry.IsDir() {
+			pods = append(pods, types.UID(entry.Name()))
+		}
+	}
+	return pods, nil
+}
+
+func (vm *volumeManager) EmptyDirLimitExceeded(pod *v1.Pod) (string, bool) {
+	for i := range pod.Spec.Volumes {
+		volume := &pod.Spec.Volumes[i]
+		if volume.EmptyDir == nil || volume.EmptyDir.SizeLimit == nil || volume.EmptyDir.SizeLimit.IsZero() {
+			continue
+		}
+		usage, err := fs.DiskUsage(vm.getPodVolumeDir(pod.UID, emptyDirPluginName, volume.Name))
+		if err != nil {
+			klog.V(4).InfoS("Failed to measure usage of emptyDir volume", "pod", klog.KObj(pod), "volumeName", volume.Name, "err", err)
+			continue
+		}
+		if usage.Bytes > volume.EmptyDir.SizeLimit.Value() {
+			return fmt.Sprintf("Usage of EmptyDir volume %q exceeds the limit %q. ", volume.Name, volume.EmptyDir.SizeLimit.String()), true
+		}
+	}
+	return "", false
+}
+
+func (vm *volumeManager) Run(stopCh <-chan struct{}) {
+	klog.InfoS("Starting to refresh service account tokens in projected volumes", "period", tokenRefreshPeriod)
+	wait.Until(vm.refreshServiceAccountTokens, tokenRefreshPeriod, stopCh)
+}
+
+// refreshServiceAccountTokens 重新生成所有包含serviceAccountToken来源的projected卷。
+// token由token manager缓存，只有超过有效期的80%时才会重新申请，内容不变时不会重写卷
+func (vm *volumeManager) refreshServiceAccountTokens() {
+	vm.lock.RLock()
+	uids := make([]types.UID, 0, len(vm.pods))
+	for uid := range vm.pods {
+		uids = append(uids, uid)
+	}
+	vm.lock.RUnlock()
+
+	for _, uid := range uids {
+		vm.refreshPodServiceAccountTokens(uid)
+	}
+}
+
+// refreshPodServiceAccountTokens 重新生成pod中已经准备好的包含serviceAccountToken来源的projected卷。
+// 申请token时只持有pod的锁，一个token申请慢或者失败不会阻塞其他pod
+func (vm *volumeManager) refreshPodServiceAccountTokens(uid types.UID) {
+	vm.podLocks.LockKey(string(uid))
+	defer vm.podLocks.UnlockKey(string(uid))
+
+	// 拿到pod的锁之前，pod可能已经被更新或者卷已经被清理，按最新的记录刷新
+	vm.lock.RLock()
+	pod, ok := vm.pods[uid]
+	var volumes []*v1.Volume
+	if ok {
+		for i := range pod.Spec.Volumes {
+			volume := &pod.Spec.Volumes[i]
+			if _, mounted := vm.mountedVolumes[uid][volume.Name]; mounted && hasServiceAccountToken(volume) {
+				volumes = append(volumes, volume)
+			}
+		}
+	}
+	vm.lock.RUnlock()
+
+	for _, volume := range volumes {
+		if _, err := vm.setUpProjected(pod, volume); err != nil {
+			klog.ErrorS(err, "Failed to refresh service account token in projected volume", "pod", klog.KObj(pod), "podUID", uid, "volumeName", volume.Name)
+		}
+	}
+}
+
+// hasServiceAccountToken 卷是否是包含serviceAccountToken来源的projected卷
+func hasServiceAccountToken(volume *v1.Volume) bool {
+	if volume.Projected == nil {
+		return false
+	}
+	for _, source := range volume.Projected.Sources {
+		if source.ServiceAccountToken != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package volumemanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/api/v1/resource"
+	podshelper "k8s.io/kubernetes/pkg/apis/core/pods"
+	"k8s.io/kubernetes/pkg/fieldpath"
+	volumeutil "k8s.io/kubernetes/pkg/volume/util"
+)
+
+// requiresRemount configMap、secret、downwardAPI和projected卷的内容来自其他对象，每次同步pod时都需要刷新
+func requiresRemount(volume *v1.Volume) bool {
+	return volume.ConfigMap != nil || volume.Secret != nil || volume.DownwardAPI != nil || volume.Projected != nil
+}
+
+// setUpVolume 准备一个卷，返回卷在宿主机上的路径，调用方需要持有锁
+func (vm *volumeManager) setUpVolume(pod *v1.Pod, volume *v1.Volume) (string, error) {
+	switch {
+	case volume.EmptyDir != nil:
+		return vm.setUpEmptyDir(pod, volume)
+	case volume.HostPath != nil:
+		return setUpHostPath(volume.HostPath)
+	case volume.ConfigMap != nil:
+		return vm.setUpConfigMap(pod, volume)
+	case volume.Secret != nil:
+		return vm.setUpSecret(pod, volume)
+	case volume.DownwardAPI != nil:
+		return vm.setUpDownwardAPI(pod, volume)
+	case volume.Projected != nil:
+		return vm.setUpProjected(pod, volume)
+	default:
+		return "", fmt.Errorf("volume type is not supported by the process runtime")
+	}
+}
+
+// setUpEmptyDir emptyDir卷就是pod目录下的一个空目录。进程运行时不挂载tmpfs，medium为Memory时同样使用磁盘，
+// sizeLimit由housekeeping定期检查，超出限制的pod会被驱逐
+func (vm *volumeManager) setUpEmptyDir(pod *v1.Pod, volume *v1.Volume) (string, error) {
+	if volume.EmptyDir.Medium == v1.StorageMediumMemory {
+		klog.V(4).InfoS("Memory medium is not supported by the process runtime, using disk instead", "pod", klog.KObj(pod), "volumeName", volume.Name)
+	}
+	dir := vm.getPodVolumeDir(pod.UID, emptyDirPluginName, volume.Name)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	// 与官方kubelet一致，emptyDir对容器中的所有用户可写
+	if err := os.Chmod(dir, 0777); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// setUpHostPath 按照hostPath的type检查宿主机上的路径，必要时创建
+func setUpHostPath(hostPath *v1.HostPathVolumeSource) (string, error) {
+	path := hostPath.Path
+	pathType := v1.HostPathUnset
+	if hostPath.Type != nil {
+		pathType = *hostPath.Type
+	}
+
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	exists := err == nil
+
+	switch pathType {
+	case v1.HostPathUnset:
+		return path, nil
+	case v1.HostPathDirectoryOrCreate:
+		if !exists {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return "", fmt.Errorf("hostPath type check failed: %s: %v", path, err)
+			}
+			return path, nil
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("hostPath type check failed: %s is not a directory", path)
+		}
+	case v1.HostPathDirectory:
+		if !exists || !info.IsDir() {
+			return "", fmt.Errorf("hostPath type check failed: %s is not a directory", path)
+		}
+	case v1.HostPathFileOrCreate:
+		if !exists {
+			f, err := os.OpenFile(path, os.O_CREATE, 0644)
+			if err != nil {
+				return "", fmt.Errorf("hostPath type check failed: %s: %v", path, err)
+			}
+			f.Close()
+			return path, nil
+		}
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("hostPath type check failed: %s is not a file", path)
+		}
+	case v1.HostPathFile:
+		if !exists || !info.Mode().IsRegular() {
+			return "", fmt.Errorf("hostPath type check failed: %s is not a file", path)
+		}
+	case v1.HostPathSocket:
+		if !exists || info.Mode()&os.ModeSocket == 0 {
+			return "", fmt.Errorf("hostPath type check failed: %s is not a socket file", path)
+		}
+	case v1.HostPathCharDev:
+		if !exists || info.Mode()&os.ModeCharDevice == 0 {
+			return "", fmt.Errorf("hostPath type check failed: %s is not a character device", path)
+		}
+	case v1.HostPathBlockDev:
+		if !exists || info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0 {
+			return "", fmt.Errorf("hostPath type check failed: %s is not a block device", path)
+		}
+	default:
+		return "", fmt.Errorf("unsupported hostPath type %q", pathType)
+	}
+	return path, nil
+}
+
+// setUpConfigMap 把configMap中的数据写到卷目录中，optional的configMap不存在时卷为空目录
+func (vm *volumeManager) setUpConfigMap(pod *v1.Pod, volume *v1.Volume) (string, error) {
+	source := volume.ConfigMap
+	optional := source.Optional != nil && *source.Optional
+	dir := vm.getPodVolumeDir(pod.UID, configMapPluginName, volume.Name)
+
+	configMap, err := vm.getConfigMap(pod.Namespace, source.Name, optional)
+	if err != nil {
+		return "", err
+	}
+	payload, err := makeConfigMapPayload(source.Items, configMap, defaultMode(source.DefaultMode, v1.ConfigMapVolumeSourceDefaultMode), optional)
+	if err != nil {
+		return "", err
+	}
+	return dir, writePayload(dir, fmt.Sprintf("configmap %s/%s for pod %s", pod.Namespace, source.Name, pod.Name), payload)
+}
+
+// setUpSecret 把secret中的数据写到卷目录中，optional的secret不存在时卷为空目录
+func (vm *volumeManager) setUpSecret(pod *v1.Pod, volume *v1.Volume) (string, error) {
+	source := volume.Secret
+	optional := source.Optional != nil && *source.Optional
+	dir := vm.getPodVolumeDir(pod.UID, secretPluginName, volume.Name)
+
+	secret, err := vm.getSecret(pod.Namespace, source.SecretName, optional)
+	if err != nil {
+		return "", err
+	}
+	payload, err := makeSecretPayload(source.Items, secret, defaultMode(source.DefaultMode, v1.SecretVolumeSourceDefaultMode), optional)
+	if err != nil {
+		return "", err
+	}
+	return dir, writePayload(dir, fmt.Sprintf("secret %s/%s for pod %s", pod.Namespace, source.SecretName, pod.Name), payload)
+}
+
+// setUpDownwardAPI 把pod的字段和容器的资源写到卷目录中
+func (vm *volumeManager) setUpDownwardAPI(pod *v1.Pod, volume *v1.Volume) (string, error) {
+	source := volume.DownwardAPI
+	dir := vm.getPodVolumeDir(pod.UID, downwardAPIPluginName, volume.Name)
+
+	payload, err := makeDownwardAPIPayload(pod, source.Items, defaultMode(source.DefaultMode, v1.DownwardAPIVolumeSourceDefaultMode))
+	if err != nil {
+		return "", err
+	}
+	return dir, writePayload(dir, fmt.Sprintf("downward API volume %s for pod %s/%s", volume.Name, pod.Namespace, pod.Name), payload)
+}
+
+// getConfigMap 获取configMap，optional的configMap不存在时返回空的configMap
+func (vm *volumeManager) getConfigMap(namespace, name string, optional bool) (*v1.ConfigMap, error) {
+	configMap, err := vm.configMapManager.GetConfigMap(namespace, name)
+	if err != nil {
+		if !errors.IsNotFound(err) || !optional {
+			return nil, err
+		}
+		configMap = &v1.ConfigMap{}
+	}
+	return configMap, nil
+}
+
+// getSecret 获取secret，optional的secret不存在时返回空的secret
+func (vm *volumeManager) getSecret(namespace, name string, optional bool) (*v1.Secret, error) {
+	secret, err := vm.secretManager.GetSecret(namespace, name)
+	if err != nil {
+		if !errors.IsNotFound(err) || !optional {
+			return nil, err
+		}
+		secret = &v1.Secret{}
+	}
+	return secret, nil
+}
+
+// makeConfigMapPayload 生成configMap对应的文件，items为空时每个key一个文件
+func makeConfigMapPayload(items []v1.KeyToPath, configMap *v1.ConfigMap, mode int32, optional bool) (map[string]volumeutil.FileProjection, error) {
+	payload := make(map[string]volumeutil.FileProjection)
+	if len(items) == 0 {
+		for name, data := range configMap.Data {
+			payload[name] = volumeutil.FileProjection{Data: []byte(data), Mode: mode}
+		}
+		for name, data := range configMap.BinaryData {
+			payload[name] = volumeutil.FileProjection{Data: data, Mode: mode}
+		}
+		return payload, nil
+	}
+	for _, ktp := range items {
+		var data []byte
+		if stringData, ok := configMap.Data[ktp.Key]; ok {
+			data = []byte(stringData)
+		} else if binaryData, ok := configMap.BinaryData[ktp.Key]; ok {
+			data = binaryData
+		} else {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("configmap references non-existent config key: %s", ktp.Key)
+		}
+		payload[ktp.Path] = volumeutil.FileProjection{Data: data, Mode: defaultMode(ktp.Mode, mode)}
+	}
+	return payload, nil
+}
+
+// makeSecretPayload 生成secret对应的文件，items为空时每个key一个文件
+func makeSecretPayload(items []v1.KeyToPath, secret *v1.Secret, mode int32, optional bool) (map[string]volumeutil.FileProjection, error) {
+	payload := make(map[string]volumeutil.FileProjection)
+	if len(items) == 0 {
+		for name, data := range secret.Data {
+			payload[name] = volumeutil.FileProjection{Data: data, Mode: mode}
+		}
+		return payload, nil
+	}
+	for _, ktp := range items {
+		data, ok := secret.Data[ktp.Key]
+		if !ok {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("references non-existent secret key: %s", ktp.Key)
+		}
+		payload[ktp.Path] = volumeutil.FileProjection{Data: data, Mode: defaultMode(ktp.Mode, mode)}
+	}
+	return payload, nil
+}
+
+// makeDownwardAPIPayload 生成pod字段和容器资源对应的文件
+func makeDownwardAPIPayload(pod *v1.Pod, items []v1.DownwardAPIVolumeFile, mode int32) (map[string]volumeutil.FileProjection, error) {
+	payload := make(map[string]volumeutil.FileProjection)
+	var errs []error
+	for _, fileInfo := range items {
+		var value string
+		var err error
+		switch {
+		case fileInfo.FieldRef != nil:
+			var fieldPath string
+			fieldPath, _, err = podshelper.ConvertDownwardAPIFieldLabel(fileInfo.FieldRef.APIVersion, fileInfo.FieldRef.FieldPath, "")
+			if err == nil {
+				value, err = fieldpath.ExtractFieldPathAsString(pod, fieldPath)
+			}
+		case fileInfo.ResourceFieldRef != nil:
+			value, err = resource.ExtractResourceValueByContainerName(fileInfo.ResourceFieldRef, pod, fileInfo.ResourceFieldRef.ContainerName)
+		}
+		if err != nil {
+			klog.ErrorS(err, "Unable to collect downward API data", "pod", klog.KObj(pod), "path", fileInfo.Path)
+			errs = append(errs, err)
+			continue
+		}
+		payload[filepath.Clean(fileInfo.Path)] = volumeutil.FileProjection{Data: []byte(value), Mode: defaultMode(fileInfo.Mode, mode)}
+	}
+	return payload, utilerrors.NewAggregate(errs)
+}
+
+// defaultMode mode为空时使用默认的权限
+func defaultMode(mode *int32, defaultMode int32) int32 {
+	if mode == nil {
+		return defaultMode
+	}
+	return *mode
+}
+
+// writePayload 用atomic writer把文件写到卷目录中：文件实际写在带时间戳的隐藏目录中，通过..data符号链接的替换原子地更新，
+// 内容没有变化时不会重新写入
+func writePayload(dir string, logContext string, payload map[string]volumeutil.FileProjection) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	writer, err := volumeutil.NewAtomicWriter(dir, logContext)
+	if err != nil {
+		return err
+	}
+	return writer.Write(payload)
+}
@@ -2,7 +2,6 @@ package mycore
 
 import (
 	"k8s.io/klog/v2"
-	"time"
 )
 
 func OnRemove(ctx *CallBackContext) error {
@@ -24,17 +23,7 @@ func OnUpdate(ctx *CallBackContext) error {
 }
 
 func OnAdd(ctx *CallBackContext) error {
-	cmds := ctx.ExecPodCommands()
-	for _, cmd := range cmds {
-		cmd.Run()
-		cmd.Cmd.Output()
-		ctx.SetContainerExit(cmd.ContainerName, cmd.ExitCode)
-	}
-
 	klog.Infof("Add: %s", ctx.Pod.Name)
 	ctx.AddNormalEvent("pod event", "add pod")
-	time.Sleep(time.Second * 3)
-	// TODO: 这里需要修改，改为完成container的所有任务才改变pod状态
-	ctx.SetPodCompleted()
 	return nil
 }
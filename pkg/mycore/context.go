@@ -20,6 +20,8 @@ type CallBackContext struct {
 
 // ExecPodCommands 当发生Pod新增事件时，执行此方法
 // 遍历容器内所有cmd，并执行
+//
+// Deprecated: 容器进程已经由process运行时在SyncPodFn中启动和管理，此方法已废弃
 func (c *CallBackContext) ExecPodCommands() []*ContainerCmd {
 	res := make([]*ContainerCmd, 0)
 	for _, c := range c.Pod.Spec.Containers {
@@ -59,31 +61,27 @@ func (c *CallBackContext) GetCommandsAndArgs() []*exec.Cmd {
 
 }
 
+// SetContainerRunning 容器进程启动后调用，用运行时中真实的状态刷新pod缓存
 func (c *CallBackContext) SetContainerRunning(containerName string) {
-	ps, err := c.podCache.InnerPodCache.Get(c.Pod.UID)
-	if err != nil {
-		klog.Error(err)
-		return
-	}
-	status := SetContainerRunning(ps, containerName)
-	c.podCache.InnerPodCache.Set(c.Pod.UID, status, nil, time.Now())
+	klog.V(3).InfoS("Container is running", "pod", klog.KObj(c.Pod), "containerName", containerName)
+	c.refreshPodStatus()
 }
 
+// SetContainerExit 容器进程退出后调用，用运行时中真实的状态刷新pod缓存
 func (c *CallBackContext) SetContainerExit(containerName string, exitCode int) {
-	ps, err := c.podCache.InnerPodCache.Get(c.Pod.UID)
+	klog.V(3).InfoS("Container exited", "pod", klog.KObj(c.Pod), "containerName", containerName, "exitCode", exitCode)
+	c.refreshPodStatus()
+}
+
+// refreshPodStatus 从运行时获取pod的状态并写入pod缓存，不需要等待PLEG的下一次relist
+func (c *CallBackContext) refreshPodStatus() {
+	timestamp := time.Now()
+	status, err := c.podCache.Runtime.GetPodStatus(c.Pod.UID, c.Pod.Name, c.Pod.Namespace)
 	if err != nil {
-		klog.Error(err)
+		klog.ErrorS(err, "Failed to get pod status from runtime", "pod", klog.KObj(c.Pod))
 		return
 	}
-
-	status := SetContainerExit(ps, c.Pod, containerName, exitCode)
-	c.podCache.InnerPodCache.Set(c.Pod.UID, status, nil, time.Now())
-}
-
-// 设置POD结束完成状态
-func (c *CallBackContext) SetPodCompleted() {
-	status := SetPodCompleted(c.Pod)
-	c.podCache.InnerPodCache.Set(c.Pod.UID, status, nil, time.Now())
+	c.podCache.InnerPodCache.Set(c.Pod.UID, status, nil, timestamp)
 }
 
 // AddNormalEvent 发送正常事件
@@ -0,0 +1,254 @@
+package mycore
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+)
+
+// evictionReason 被驱逐的pod状态中的reason
+const evictionReason = "Evicted"
+
+// containerGCPolicy 已经退出的容器实例的回收策略，与kubelet的默认参数一致：
+// 每个容器只保留最近一个退出的实例（用于kubectl logs --previous），不限制总数
+var containerGCPolicy = kubecontainer.GCPolicy{
+	MinAge:             0,
+	MaxPerPodContainer: 1,
+	MaxContainers:      -1,
+}
+
+// HandlePodCleanups 定期的清理工作。对比PodManager中期望的pod、pod worker和运行时中实际的pod，
+// 回收已经不需要的pod worker、探针、状态缓存、镜像pod，并停止运行时中不再需要的pod。
+// 必须在syncLoop中调用，保证清理期间不会有新的配置变化
+func (k *SampleKubelet) HandlePodCleanups() error {
+	pc := k.podCache
+	pf := pc.PodFn
+
+	allPods, mirrorPods := pc.PodManager.GetPodsAndMirrorPods()
+	// 先取出孤儿镜像pod，避免pod worker清理后误判
+	orphanedMirrorPodFullnames := pc.PodManager.GetOrphanedMirrorPodNames()
+
+	// Stop the workers for terminated pods not in the config source
+	klog.V(3).InfoS("Clean up pod workers for terminated pods")
+	workingPods := pc.PodWorkers.SyncKnownPods(allPods)
+
+	allPodsByUID := make(map[types.UID]*v1.Pod)
+	for _, pod := range allPods {
+		allPodsByUID[pod.UID] = pod
+	}
+
+	// Identify the set of pods that have workers, which should be all pods
+	// from config that are not terminated, as well as any terminating pods
+	// that have already been removed from config.
+	possiblyRunningPods := make(map[types.UID]sets.Empty)
+	for uid, state := range workingPods {
+		switch state {
+		case SyncPod, TerminatingPod:
+			possiblyRunningPods[uid] = struct{}{}
+		}
+	}
+
+	// Stop probing pods that are not running
+	klog.V(3).InfoS("Clean up probes for terminated pods")
+	pf.probeManager.CleanupPods(possiblyRunningPods)
+
+	// Remove orphaned pod statuses not in the total list of known config pods
+	klog.V(3).InfoS("Clean up orphaned pod statuses")
+	removeOrphanedPodStatuses(pf, allPods, mirrorPods)
+
+	// 运行时中存在、但pod worker不知道的pod，比如kubelet重启前遗留的进程，需要停止
+	runningRuntimePods, err := pc.Runtime.GetPods(false)
+	if err != nil {
+		klog.ErrorS(err, "Error listing containers")
+		return err
+	}
+	for _, runningPod := range runningRuntimePods {
+		switch state, ok := workingPods[runningPod.ID]; {
+		case ok && (state == SyncPod || state == TerminatingPod):
+			// if the pod worker is already in charge of this pod, we don't need to do anything
+			continue
+		default:
+			if _, ok := allPodsByUID[runningPod.ID]; !ok {
+				klog.V(3).InfoS("Clean up orphaned pod containers", "podUID", runningPod.ID)
+				one := int64(1)
+				pc.PodWorkers.UpdatePod(UpdatePodOptions{
+					UpdateType: kubetypes.SyncPodKill,
+					RunningPod: runningPod,
+					KillPodOptions: &KillPodOptions{
+						PodTerminationGracePeriodSecondsOverride: &one,
+					},
+				})
+			}
+		}
+	}
+
+	// Remove any orphaned mirror pods (mirror pods are tracked by name via the
+	// pod worker)
+	klog.V(3).InfoS("Clean up orphaned mirror pods")
+	for _, podFullname := range orphanedMirrorPodFullnames {
+		if !pc.PodWorkers.IsPodForMirrorPodTerminatingByFullName(podFullname) {
+			_, err := pc.PodManager.DeleteMirrorPod(podFullname, nil)
+			if err != nil {
+				klog.ErrorS(err, "Encountered error when deleting mirror pod", "podName", podFullname)
+			} else {
+				klog.V(3).InfoS("Deleted mirror pod", "podName", podFullname)
+			}
+		}
+	}
+
+	// 删除已经不存在的pod遗留在宿主机上的目录和卷
+	klog.V(3).InfoS("Clean up orphaned pod directories")
+	if err := cleanupOrphanedPodDirs(pc, allPodsByUID, workingPods, runningRuntimePods); err != nil {
+		// We want all cleanup tasks to be run even if one of them failed. So
+		// we just log an error here and continue other cleanup tasks.
+		// This also applies to the other clean up tasks.
+		klog.ErrorS(err, "Failed cleaning up orphaned pod directories")
+	}
+
+	// 回收已经退出的容器实例，以及已经不存在的pod在运行时中的记录
+	klog.V(3).InfoS("Clean up dead containers")
+	if err := pc.Runtime.GarbageCollect(containerGCPolicy, k.sourcesReady.AllReady(), false); err != nil {
+		klog.ErrorS(err, "Container garbage collection failed")
+	}
+	if err := removeOrphanedRuntimePods(pc, allPodsByUID, workingPods); err != nil {
+		klog.ErrorS(err, "Failed cleaning up orphaned runtime pods")
+	}
+
+	// emptyDir超过sizeLimit的pod会被驱逐
+	evictPodsExceedingEmptyDirLimit(pc, allPods, workingPods)
+
+	// Cleanup any backoff entries.
+	pf.backOff.GC()
+
+	// 同一个UID的pod在停止后又被要求重新启动时，SyncKnownPods会忘记它，这里重新交给pod worker
+	for _, desiredPod := range allPods {
+		if _, knownPod := workingPods[desiredPod.UID]; knownPod {
+			continue
+		}
+		if desiredPod.Status.Phase == v1.PodSucceeded || desiredPod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		klog.V(3).InfoS("Pod will be restarted because it is in the desired set and not known to the pod workers (likely due to UID reuse)", "podUID", desiredPod.UID)
+		mirrorPod, _ := pc.PodManager.GetMirrorPodByPod(desiredPod)
+		pc.PodWorkers.UpdatePod(UpdatePodOptions{
+			UpdateType: kubetypes.SyncPodCreate,
+			StartTime:  pc.Clock.Now(),
+			Pod:        desiredPod,
+			MirrorPod:  mirrorPod,
+		})
+	}
+	return nil
+}
+
+// removeOrphanedPodStatuses 删除status manager中已经不存在的pod的状态
+func removeOrphanedPodStatuses(pf *PodFn, pods []*v1.Pod, mirrorPods []*v1.Pod) {
+	podUIDs := make(map[types.UID]bool)
+	for _, pod := range pods {
+		podUIDs[pod.UID] = true
+	}
+	for _, pod := range mirrorPods {
+		podUIDs[pod.UID] = true
+	}
+	pf.statusManager.RemoveOrphanedStatuses(podUIDs)
+}
+
+// cleanupOrphanedPodDirs 删除配置中和pod worker中都已经不存在、并且没有运行中进程的pod的目录，
+// 包括终止时保留下来的容器日志
+func cleanupOrphanedPodDirs(pc *PodCache, allPods map[types.UID]*v1.Pod, workingPods map[types.UID]PodWorkerState, runningPods []*kubecontainer.Pod) error {
+	podUIDs, err := pc.VolumeManager.ListPodsOnDisk()
+	if err != nil {
+		return err
+	}
+	runningPodUIDs := sets.NewString()
+	for _, pod := range runningPods {
+		runningPodUIDs.Insert(string(pod.ID))
+	}
+	var errs []error
+	for _, uid := range podUIDs {
+		if _, ok := allPods[uid]; ok {
+			continue
+		}
+		if _, ok := workingPods[uid]; ok {
+			continue
+		}
+		// 进程还没有被停止，下一次清理时再处理
+		if runningPodUIDs.Has(string(uid)) {
+			continue
+		}
+		klog.V(3).InfoS("Orphaned pod found, removing pod directory", "podUID", uid)
+		if err := pc.VolumeManager.RemovePodDir(uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// removeOrphanedRuntimePods 删除运行时中已经停止、且不在配置中也没有pod worker的pod的记录，
+// 比如已经完成后又被删除的pod
+func removeOrphanedRuntimePods(pc *PodCache, allPods map[types.UID]*v1.Pod, workingPods map[types.UID]PodWorkerState) error {
+	runtimePods, err := pc.Runtime.GetPods(true)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, pod := range runtimePods {
+		if _, ok := allPods[pod.ID]; ok {
+			continue
+		}
+		if _, ok := workingPods[pod.ID]; ok {
+			continue
+		}
+		// 进程还没有被停止，下一次清理时再处理
+		if hasRunningContainers(pod) {
+			continue
+		}
+		klog.V(3).InfoS("Orphaned pod found in runtime, removing it", "podUID", pod.ID)
+		if err := pc.PodFn.containerRuntime.RemovePod(pod.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func hasRunningContainers(pod *kubecontainer.Pod) bool {
+	for _, c := range pod.Containers {
+		if c.State == kubecontainer.ContainerStateRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// evictPodsExceedingEmptyDirLimit 驱逐emptyDir使用量超过sizeLimit的pod。
+// 进程运行时的emptyDir只是宿主机上的目录，无法像tmpfs那样限制大小，和上游的eviction manager一样通过驱逐pod来保证sizeLimit
+func evictPodsExceedingEmptyDirLimit(pc *PodCache, pods []*v1.Pod, workingPods map[types.UID]PodWorkerState) {
+	for _, pod := range pods {
+		if state, ok := workingPods[pod.UID]; !ok || state != SyncPod {
+			continue
+		}
+		message, exceeded := pc.VolumeManager.EmptyDirLimitExceeded(pod)
+		if !exceeded {
+			continue
+		}
+		pc.PodFn.recorder.Event(pod, v1.EventTypeWarning, evictionReason, message)
+		klog.V(3).InfoS("Evicting pod", "pod", klog.KObj(pod), "podUID", pod.UID, "message", message)
+		gracePeriodOverride := int64(0)
+		pc.PodWorkers.UpdatePod(UpdatePodOptions{
+			UpdateType: kubetypes.SyncPodKill,
+			Pod:        pod,
+			KillPodOptions: &KillPodOptions{
+				Evict: true,
+				PodStatusFunc: func(status *v1.PodStatus) {
+					status.Phase = v1.PodFailed
+					status.Reason = evictionReason
+					status.Message = message
+				},
+				PodTerminationGracePeriodSecondsOverride: &gracePeriodOverride,
+			},
+		})
+	}
+}
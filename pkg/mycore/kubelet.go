@@ -1,12 +1,32 @@
 package mycore
 
 import (
+	"fmt"
+	"math"
+	"time"
+
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/kubelet/config"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/pleg"
+	proberesults "k8s.io/kubernetes/pkg/kubelet/prober/results"
+	serverstats "k8s.io/kubernetes/pkg/kubelet/server/stats"
+	"k8s.io/kubernetes/pkg/kubelet/stats"
 	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
 )
 
+const (
+	// housekeepingPeriod 定期清理的周期
+	housekeepingPeriod = time.Second * 2
+	// housekeepingWarningDuration 清理超过这个时间会打印错误日志
+	housekeepingWarningDuration = time.Second * 15
+)
+
 // SampleKubelet 简易kubelet
 type SampleKubelet struct {
 	// podCache pod缓存，
@@ -19,44 +39,256 @@ type SampleKubelet struct {
 	onDelete CallBackFunc
 	// onRemove 删除事件回调
 	onRemove CallBackFunc
+	// runtimeState 各组件的健康状况
+	runtimeState *runtimeState
+	// sourcesReady 记录已经看到过的pod来源，所有来源都就绪之前不做清理
+	sourcesReady config.SourcesReady
+	// summaryProvider 提供/stats/summary和/metrics/resource中的资源使用统计
+	summaryProvider serverstats.SummaryProvider
 }
 
 func (k *SampleKubelet) SetOnPreAdd(onAdd func(pod *v1.Pod) error) {
 	k.podCache.PodWorkers.(*podWorkers).OnPreAdd = onAdd
 }
 
-// Start 启动kubelet，主要是不断从podCache.PodConfig.Updates()中chan
-// 获取包装过的pod对象，并区分不同事件，进入相应的handler
+// Start 启动kubelet，先启动PLEG和卷管理器，然后进入syncLoop
 func (k *SampleKubelet) Start() {
 	klog.Info("sample kubelet start...")
-	for item := range k.podCache.PodConfig.Updates() {
-		pods := item.Pods
-		switch item.Op {
+	k.podCache.PLEG.Start()
+	go k.podCache.VolumeManager.Run(wait.NeverStop)
+	k.syncLoop(k.podCache.PodConfig.Updates())
+}
+
+// syncLoop kubelet的主循环。运行时不健康时会退避，直到恢复后再继续处理各个channel
+func (k *SampleKubelet) syncLoop(updates <-chan kubetypes.PodUpdate) {
+	klog.InfoS("Starting kubelet main sync loop")
+	// The syncTicker wakes up kubelet to checks if there are any pod workers
+	// that need to be sync'd. A one-second period is sufficient because the
+	// sync interval is defaulted to 10s.
+	syncTicker := time.NewTicker(time.Second)
+	defer syncTicker.Stop()
+	housekeepingTicker := time.NewTicker(housekeepingPeriod)
+	defer housekeepingTicker.Stop()
+	plegCh := k.podCache.PLEG.Watch()
+	const (
+		base   = 100 * time.Millisecond
+		max    = 5 * time.Second
+		factor = 2
+	)
+	duration := base
+	for {
+		if err := k.runtimeState.runtimeErrors(); err != nil {
+			klog.ErrorS(err, "Skipping pod synchronization")
+			// exponential backoff
+			time.Sleep(duration)
+			duration = time.Duration(math.Min(float64(max), factor*float64(duration)))
+			continue
+		}
+		// reset backoff if we have a success
+		duration = base
+
+		if !k.syncLoopIteration(updates, syncTicker.C, housekeepingTicker.C, plegCh) {
+			break
+		}
+	}
+}
+
+// syncLoopIteration reads from various channels and dispatches pods to the
+// given handler.
+//
+// Arguments:
+// 1.  configCh:       a channel to read config events from
+// 2.  syncCh:         a channel to read periodic sync events from
+// 3.  housekeepingCh: a channel to read housekeeping events from
+// 4.  plegCh:         a channel to read PLEG updates from
+//
+// Events are also read from the kubelet liveness, readiness and startup
+// managers.
+//
+// The workflow is to read from one of the channels, handle that event, and
+// update the timestamp in the sync loop monitor.
+//
+// Here is an appropriate place to note that despite the syntactical
+// similarity to the switch statement, the case statements in a select are
+// evaluated in a pseudorandom order if there are multiple channels ready to
+// read from when the select is evaluated.  In other words, case statements
+// are evaluated in random order, and you can not assume that the case
+// statements evaluate in order if multiple channels have events.
+//
+// With that in mind, in truly no particular order, the different channels
+// are handled as follows:
+//
+//   - configCh: dispatch the pods for the config change to the appropriate
+//     handler callback for the event type
+//   - plegCh: update the runtime cache; sync pod
+//   - syncCh: sync all pods waiting for sync
+//   - housekeepingCh: trigger cleanup of pods
+//   - health manager: sync pods that have failed or in which one or more
+//     containers have failed health checks
+func (k *SampleKubelet) syncLoopIteration(configCh <-chan kubetypes.PodUpdate, syncCh <-chan time.Time,
+	housekeepingCh <-chan time.Time, plegCh chan *pleg.PodLifecycleEvent) bool {
+	pf := k.podCache.PodFn
+	select {
+	case u, open := <-configCh:
+		// Update from a config source; dispatch it to the right handler
+		// callback.
+		if !open {
+			klog.ErrorS(nil, "Update channel is closed, exiting the sync loop")
+			return false
+		}
+
+		switch u.Op {
 		case kubetypes.ADD:
-			HandlerPodAdd(pods, k.podCache, k.onAdd)
+			klog.V(2).InfoS("SyncLoop ADD", "source", u.Source, "pods", klog.KObjSlice(u.Pods))
+			HandlerPodAdd(u.Pods, k.podCache, k.onAdd)
 		case kubetypes.UPDATE:
-			klog.Info("进入update")
-			HandlePodUpdate(pods, k.podCache, k.onUpdate)
-			break
-		case kubetypes.DELETE:
-			klog.Info("进入delete")
-			HandlePodUpdate(pods, k.podCache, k.onDelete)
-			break
+			klog.V(2).InfoS("SyncLoop UPDATE", "source", u.Source, "pods", klog.KObjSlice(u.Pods))
+			HandlePodUpdate(u.Pods, k.podCache, k.onUpdate)
 		case kubetypes.REMOVE:
-			klog.Info("进入remove")
-			HandlePodRemove(pods, k.podCache, k.onRemove)
+			klog.V(2).InfoS("SyncLoop REMOVE", "source", u.Source, "pods", klog.KObjSlice(u.Pods))
+			HandlePodRemove(u.Pods, k.podCache, k.onRemove)
+		case kubetypes.RECONCILE:
+			klog.V(4).InfoS("SyncLoop RECONCILE", "source", u.Source, "pods", klog.KObjSlice(u.Pods))
+			HandlePodReconcile(u.Pods, k.podCache)
+		case kubetypes.DELETE:
+			klog.V(2).InfoS("SyncLoop DELETE", "source", u.Source, "pods", klog.KObjSlice(u.Pods))
+			HandlePodDelete(u.Pods, k.podCache, k.onDelete)
+		case kubetypes.SET:
+			klog.V(2).InfoS("SyncLoop SET", "source", u.Source, "pods", klog.KObjSlice(u.Pods))
+			HandlePodSet(u.Pods, u.Source, k.podCache, k.onAdd, k.onUpdate, k.onRemove)
+		default:
+			klog.ErrorS(nil, "Invalid operation type received", "operation", u.Op)
+		}
+
+		k.sourcesReady.AddSource(u.Source)
+
+	case e := <-plegCh:
+		if isSyncPodWorthy(e) {
+			// PLEG event for a pod; sync it.
+			if pod, ok := k.podCache.PodManager.GetPodByUID(e.ID); ok {
+				klog.V(2).InfoS("SyncLoop (PLEG): event for pod", "pod", klog.KObj(pod), "event", e)
+				HandlePodSyncs([]*v1.Pod{pod}, k.podCache)
+			} else {
+				// If the pod no longer exists, ignore the event.
+				klog.V(4).InfoS("SyncLoop (PLEG): pod does not exist, ignore irrelevant event", "event", e)
+			}
+		}
+	case <-syncCh:
+		// Sync pods waiting for sync
+		podsToSync := k.getPodsToSync()
+		if len(podsToSync) == 0 {
 			break
 		}
+		klog.V(4).InfoS("SyncLoop (SYNC) pods", "total", len(podsToSync), "pods", klog.KObjSlice(podsToSync))
+		HandlePodSyncs(podsToSync, k.podCache)
+	case update := <-pf.livenessManager.Updates():
+		if update.Result == proberesults.Failure {
+			k.handleProbeSync(update, "liveness", "unhealthy")
+		}
+	case update := <-pf.readinessManager.Updates():
+		ready := update.Result == proberesults.Success
+		pf.statusManager.SetContainerReadiness(update.PodUID, update.ContainerID, ready)
+
+		status := ""
+		if ready {
+			status = "ready"
+		}
+		k.handleProbeSync(update, "readiness", status)
+	case update := <-pf.startupManager.Updates():
+		started := update.Result == proberesults.Success
+		pf.statusManager.SetContainerStartup(update.PodUID, update.ContainerID, started)
+
+		status := "unhealthy"
+		if started {
+			status = "started"
+		}
+		k.handleProbeSync(update, "startup", status)
+	case <-housekeepingCh:
+		if !k.sourcesReady.AllReady() {
+			// If the sources aren't ready or volume manager has not yet synced the states,
+			// skip housekeeping, as we may accidentally delete pods from unready sources.
+			klog.V(4).InfoS("SyncLoop (housekeeping, skipped): sources aren't ready yet")
+		} else {
+			start := time.Now()
+			klog.V(4).InfoS("SyncLoop (housekeeping)")
+			if err := k.HandlePodCleanups(); err != nil {
+				klog.ErrorS(err, "Failed cleaning pods")
+			}
+			duration := time.Since(start)
+			if duration > housekeepingWarningDuration {
+				klog.ErrorS(fmt.Errorf("housekeeping took too long"), "Housekeeping took longer than expected", "expected", housekeepingWarningDuration, "actual", duration.Round(time.Millisecond))
+			}
+			klog.V(4).InfoS("SyncLoop (housekeeping) end", "duration", duration.Round(time.Millisecond))
+		}
 	}
+	return true
 }
 
-func NewSampleKubelet(client *kubernetes.Clientset, nodeName string) *SampleKubelet {
-	pc := NewPodCache(client, nodeName)
-	return &SampleKubelet{
-		podCache: pc,
-		onAdd:    OnAdd,
-		onUpdate: OnUpdate,
-		onDelete: OnDelete,
-		onRemove: OnRemove,
+// handleProbeSync 探测结果发生变化时，触发对应pod的同步
+func (k *SampleKubelet) handleProbeSync(update proberesults.Update, probe, status string) {
+	// We should not use the pod from manager, because it is never updated after initialization.
+	pod, ok := k.podCache.PodManager.GetPodByUID(update.PodUID)
+	if !ok {
+		// If the pod no longer exists, ignore the update.
+		klog.V(4).InfoS("SyncLoop (probe): ignore irrelevant update", "probe", probe, "status", status, "update", update)
+		return
+	}
+	klog.V(1).InfoS("SyncLoop (probe)", "probe", probe, "status", status, "pod", klog.KObj(pod))
+	HandlePodSyncs([]*v1.Pod{pod}, k.podCache)
+}
+
+// getPodsToSync returns pods which should be resynchronized. Currently, the
+// following pods should be resynchronized:
+//   - pods whose work is ready.
+func (k *SampleKubelet) getPodsToSync() []*v1.Pod {
+	allPods := k.podCache.PodManager.GetPods()
+	podUIDs := k.podCache.WorkQueue.GetWork()
+	podUIDSet := sets.NewString()
+	for _, podUID := range podUIDs {
+		podUIDSet.Insert(string(podUID))
 	}
+	var podsToSync []*v1.Pod
+	for _, pod := range allPods {
+		if podUIDSet.Has(string(pod.UID)) {
+			// The work of the pod is ready
+			podsToSync = append(podsToSync, pod)
+		}
+	}
+	return podsToSync
+}
+
+// isSyncPodWorthy filters out events that are not worthy of pod syncing
+func isSyncPodWorthy(event *pleg.PodLifecycleEvent) bool {
+	// ContainerRemoved doesn't affect pod state
+	return event.Type != pleg.ContainerRemoved
+}
+
+// Healthz 返回kubelet各组件的健康状况，比如PLEG长时间没有relist成功时会返回错误
+func (k *SampleKubelet) Healthz() error {
+	return k.runtimeState.runtimeErrors()
+}
+
+func NewSampleKubelet(client *kubernetes.Clientset, nodeName string, rootDir string,
+	changeDetectionStrategy ResourceChangeDetectionStrategy, containerLogMaxSize int64, containerLogMaxFiles int) (*SampleKubelet, error) {
+	pc, err := NewPodCache(client, nodeName, rootDir, changeDetectionStrategy, containerLogMaxSize, containerLogMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	rs := newRuntimeState()
+	rs.addHealthCheck("PLEG", pc.PLEG.Healthy)
+	// 容器进程启动或者退出时立即刷新状态，不必等待PLEG的relist
+	pc.Runtime.AddContainerStateListener(func(podUID types.UID, containerName string, state kubecontainer.State, exitCode int) {
+		HandleContainerStateChange(podUID, containerName, state, exitCode, pc)
+	})
+	summaryProvider := serverstats.NewSummaryProvider(stats.NewProvider(nodeName, rootDir, pc.Runtime, pc.VolumeManager))
+	return &SampleKubelet{
+		podCache:        pc,
+		onAdd:           OnAdd,
+		onUpdate:        OnUpdate,
+		onDelete:        OnDelete,
+		onRemove:        OnRemove,
+		runtimeState:    rs,
+		sourcesReady:    config.NewSourcesReady(pc.PodConfig.SeenAllSources),
+		summaryProvider: summaryProvider,
+	}, nil
 }
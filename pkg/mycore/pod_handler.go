@@ -2,7 +2,10 @@ package mycore
 
 import (
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/status"
 	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
 )
 
@@ -88,3 +91,123 @@ func HandlerPodAdd(pods []*v1.Pod, pc *PodCache, f CallBackFunc) {
 		}
 	}
 }
+
+// HandlePodSyncs 触发pod的同步，比如容器状态发生变化时
+func HandlePodSyncs(pods []*v1.Pod, pc *PodCache) {
+	start := pc.Clock.Now()
+	for _, p := range pods {
+		mirrorPod, _ := pc.PodManager.GetMirrorPodByPod(p)
+		pc.PodWorkers.UpdatePod(UpdatePodOptions{
+			UpdateType: kubetypes.SyncPodSync,
+			StartTime:  start,
+			Pod:        p,
+			MirrorPod:  mirrorPod,
+		})
+	}
+}
+
+// HandlePodDelete 当pod被优雅删除（设置了deletionTimestamp）时，处理的handler
+func HandlePodDelete(pods []*v1.Pod, pc *PodCache, f CallBackFunc) {
+	start := pc.Clock.Now()
+	for _, p := range pods {
+		// 更新PodManager缓存，pod在停止完成之前仍然是已知的
+		pc.PodManager.UpdatePod(p)
+		mirrorPod, _ := pc.PodManager.GetMirrorPodByPod(p)
+		// pod带有deletionTimestamp，pod worker会按照宽限期停止pod
+		pc.PodWorkers.UpdatePod(UpdatePodOptions{
+			UpdateType: kubetypes.SyncPodKill,
+			StartTime:  start,
+			Pod:        p,
+			MirrorPod:  mirrorPod,
+		})
+		// 执行回调方法
+		if f != nil {
+			ctx := &CallBackContext{
+				Pod:      p,
+				recorder: pc.PodWorkers.(*podWorkers).recorder,
+				podCache: pc,
+			}
+			err := f(ctx)
+			if err != nil {
+				ctx.AddWarningEvent("delete error", "delete pod error")
+				klog.Error(err)
+			}
+		}
+	}
+}
+
+// HandlePodReconcile 当apiserver中pod的状态和kubelet不一致时，处理的handler
+func HandlePodReconcile(pods []*v1.Pod, pc *PodCache) {
+	start := pc.Clock.Now()
+	for _, p := range pods {
+		// Update the pod in pod manager, status manager will do periodically reconcile according
+		// to the pod manager.
+		pc.PodManager.UpdatePod(p)
+
+		// Reconcile Pod "Ready" condition if necessary. Trigger sync pod for reconciliation.
+		if status.NeedToReconcilePodReadiness(p) {
+			mirrorPod, _ := pc.PodManager.GetMirrorPodByPod(p)
+			pc.PodWorkers.UpdatePod(UpdatePodOptions{
+				UpdateType: kubetypes.SyncPodSync,
+				StartTime:  start,
+				Pod:        p,
+				MirrorPod:  mirrorPod,
+			})
+		}
+	}
+}
+
+// HandlePodSet 收到某个来源的全量pod时，处理的handler。
+// 新出现的pod按新增处理，已知的pod按更新处理，该来源中不再出现的pod按移除处理
+func HandlePodSet(pods []*v1.Pod, source string, pc *PodCache, onAdd, onUpdate, onRemove CallBackFunc) {
+	desired := make(map[types.UID]struct{}, len(pods))
+	var added, updated, removed []*v1.Pod
+	for _, p := range pods {
+		desired[p.UID] = struct{}{}
+		if _, ok := pc.PodManager.GetPodByUID(p.UID); ok {
+			updated = append(updated, p)
+		} else {
+			added = append(added, p)
+		}
+	}
+	for _, p := range pc.PodManager.GetPods() {
+		if _, ok := desired[p.UID]; ok {
+			continue
+		}
+		podSource, err := kubetypes.GetPodSource(p)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get pod source", "pod", klog.KObj(p))
+			continue
+		}
+		if source == kubetypes.AllSource || podSource == source {
+			removed = append(removed, p)
+		}
+	}
+
+	HandlerPodAdd(added, pc, onAdd)
+	HandlePodUpdate(updated, pc, onUpdate)
+	HandlePodRemove(removed, pc, onRemove)
+}
+
+// HandleContainerStateChange 容器进程启动或者退出时，处理的handler。
+// 通过CallBackContext用运行时中真实的状态更新pod缓存，然后触发pod的同步
+func HandleContainerStateChange(podUID types.UID, containerName string, state kubecontainer.State, exitCode int, pc *PodCache) {
+	pod, ok := pc.PodManager.GetPodByUID(podUID)
+	if !ok {
+		// 配置中已经不存在的pod由housekeeping清理
+		klog.V(4).InfoS("Container state changed for unknown pod, ignore it", "podUID", podUID, "containerName", containerName)
+		return
+	}
+	ctx := &CallBackContext{
+		Pod:      pod,
+		recorder: pc.PodWorkers.(*podWorkers).recorder,
+		podCache: pc,
+	}
+	switch state {
+	case kubecontainer.ContainerStateRunning:
+		ctx.SetContainerRunning(containerName)
+	case kubecontainer.ContainerStateExited:
+		ctx.SetContainerExit(containerName, exitCode)
+	}
+	HandlePodSyncs([]*v1.Pod{pod}, pc)
+}
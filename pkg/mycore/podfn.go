@@ -5,19 +5,25 @@ import (
 	"fmt"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/klog/v2"
 	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
 	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/events"
+	"k8s.io/kubernetes/pkg/kubelet/metrics"
 	"k8s.io/kubernetes/pkg/kubelet/prober"
 	"k8s.io/kubernetes/pkg/kubelet/prober/results"
+	"k8s.io/kubernetes/pkg/kubelet/process"
 	"k8s.io/kubernetes/pkg/kubelet/status"
 	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/pkg/kubelet/volumemanager"
 	"sort"
+	"time"
 )
 
-
 func SyncPodFn(ctx context.Context, updateType kubetypes.SyncPodType, pod *v1.Pod, mirrorPod *v1.Pod, podStatus *kubecontainer.PodStatus) (bool, error) {
 	fmt.Println("临时的syncpod函数")
 
@@ -42,59 +48,196 @@ type PodFn struct {
 	reasonCache   *ReasonCache
 	recorder      record.EventRecorder
 	probeManager  prober.Manager
+	// 探针结果管理器，syncLoop通过它们的Updates()感知探测结果的变化
+	livenessManager  results.Manager
+	readinessManager results.Manager
+	startupManager   results.Manager
+	// containerRuntime 容器运行时，pod中的容器由它启动和停止
+	containerRuntime process.ProcessRuntime
+	// backOff 容器重启的退避
+	backOff *flowcontrol.Backoff
+	// volumeManager 启动容器之前准备pod的卷，pod终止后清理
+	volumeManager volumemanager.VolumeManager
 }
 
-func NewPodFn(client *kubernetes.Clientset, statusManager status.Manager, recorder record.EventRecorder) *PodFn {
-	// 存活、就绪、启动探针管理器
-	lm, rm, sm := results.NewManager(), results.NewManager(), results.NewManager()
-	cmdRunner := &CmdRunner{}
-	pm := prober.NewManager(statusManager, lm, rm, sm, cmdRunner, recorder)
+const (
+	// backOffPeriod 容器重启退避的初始时间
+	backOffPeriod = time.Second * 10
+	// MaxContainerBackOff 容器重启退避的最长时间
+	MaxContainerBackOff = 300 * time.Second
+)
+
+func NewPodFn(client *kubernetes.Clientset, statusManager status.Manager, recorder record.EventRecorder,
+	runtime process.ProcessRuntime, runner kubecontainer.CommandRunner, volumeManager volumemanager.VolumeManager, lm, rm, sm results.Manager) *PodFn {
+	// 存活、就绪、启动探针管理器，exec探针通过runner在容器中执行命令
+	pm := prober.NewManager(statusManager, lm, rm, sm, runner, recorder)
 	return &PodFn{
-		kubeClient:    client,
-		statusManager: statusManager,
-		reasonCache:   NewReasonCache(),
-		recorder:      recorder,
-		probeManager:  pm,
+		kubeClient:       client,
+		statusManager:    statusManager,
+		reasonCache:      NewReasonCache(),
+		recorder:         recorder,
+		probeManager:     pm,
+		livenessManager:  lm,
+		readinessManager: rm,
+		startupManager:   sm,
+		containerRuntime: runtime,
+		backOff:          flowcontrol.NewBackOff(backOffPeriod, MaxContainerBackOff),
+		volumeManager:    volumeManager,
 	}
 }
 
+// SyncTerminatingFn 停止pod中所有的容器进程
 func (pf *PodFn) SyncTerminatingFn(_ context.Context, pod *v1.Pod, podStatus *kubecontainer.PodStatus, runningPod *kubecontainer.Pod, gracePeriod *int64, podStatusFn func(*v1.PodStatus)) error {
-	fmt.Println("临时的SyncTerminating函数")
-	fmt.Println("runningpod: ", pod.Name)
-	pod_status := pf.generateAPIPodStatus(pod, podStatus)
-	pf.statusManager.SetPodStatus(pod, pod_status)
+	klog.V(4).InfoS("syncTerminatingPod enter", "pod", klog.KObj(pod), "podUID", pod.UID)
+	defer klog.V(4).InfoS("syncTerminatingPod exit", "pod", klog.KObj(pod), "podUID", pod.UID)
+
+	// 停止pod时不再需要存活和启动探针，避免探测失败导致容器被重启
+	pf.probeManager.StopLivenessAndStartup(pod)
+
+	// 配置中已经不存在、只在运行时中存在的pod，不需要更新状态，停止进程即可
+	if runningPod != nil {
+		if err := pf.containerRuntime.KillPod(pod, *runningPod, gracePeriod); err != nil {
+			pf.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedToKillPod, "error killing pod: %v", err)
+			utilruntime.HandleError(err)
+			return err
+		}
+		klog.V(4).InfoS("Pod termination stopped all running orphan containers", "pod", klog.KObj(pod), "podUID", pod.UID)
+		return nil
+	}
+
+	apiPodStatus := pf.generateAPIPodStatus(pod, podStatus)
+	if podStatusFn != nil {
+		podStatusFn(&apiPodStatus)
+	}
+	pf.statusManager.SetPodStatus(pod, apiPodStatus)
+
+	p := kubecontainer.ConvertPodStatusToRunningPod(pf.containerRuntime.Type(), podStatus)
+	if err := pf.containerRuntime.KillPod(pod, p, gracePeriod); err != nil {
+		pf.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedToKillPod, "error killing pod: %v", err)
+		utilruntime.HandleError(err)
+		return err
+	}
+
+	// 容器进程都已经停止，移除pod的所有探针
+	pf.probeManager.RemovePod(pod)
+
+	// 确认所有的容器进程都已经退出
+	podStatus, err := pf.containerRuntime.GetPodStatus(pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		klog.ErrorS(err, "Unable to read pod status prior to final pod termination", "pod", klog.KObj(pod), "podUID", pod.UID)
+		return err
+	}
+	if runningContainers := runningContainerIDs(podStatus); len(runningContainers) > 0 {
+		return fmt.Errorf("detected running containers after a successful KillPod: %v", runningContainers)
+	}
+
+	// 用容器的最终状态（包括退出码）更新pod状态
+	apiPodStatus = pf.generateAPIPodStatus(pod, podStatus)
+	pf.statusManager.SetPodStatus(pod, apiPodStatus)
+
+	klog.V(4).InfoS("Pod termination stopped all running containers", "pod", klog.KObj(pod), "podUID", pod.UID)
 	return nil
 }
 
-// the function to invoke to terminate a pod (ensure no running processes are present)
+// SyncTerminatedFn 写入pod最终的状态
 func (pf *PodFn) SyncTerminatedFn(ctx context.Context, pod *v1.Pod, podStatus *kubecontainer.PodStatus) error {
-	fmt.Println("临时的SyncTerminated函数")
-	pod_status := pf.generateAPIPodStatus(pod, podStatus)
-	pf.statusManager.SetPodStatus(pod, pod_status)
+	klog.V(4).InfoS("syncTerminatedPod enter", "pod", klog.KObj(pod), "podUID", pod.UID)
+	defer klog.V(4).InfoS("syncTerminatedPod exit", "pod", klog.KObj(pod), "podUID", pod.UID)
+
+	// 进入终态之前再次确认pod中没有残留的进程，否则返回错误交给pod worker重试
+	runtimeStatus, err := pf.containerRuntime.GetPodStatus(pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		return err
+	}
+	if runningContainers := runningContainerIDs(runtimeStatus); len(runningContainers) > 0 {
+		return fmt.Errorf("detected running containers for terminated pod: %v", runningContainers)
+	}
+
+	// 进程都已经退出，清理pod的卷和容器的根目录。容器的日志留在pod目录中，等pod被删除后由housekeeping删除
+	if err := pf.volumeManager.UnmountVolumesForPod(pod.UID); err != nil {
+		klog.ErrorS(err, "Unable to tear down volumes of terminated pod", "pod", klog.KObj(pod), "podUID", pod.UID)
+		return err
+	}
+
+	apiPodStatus := pf.generateAPIPodStatus(pod, podStatus)
+	pf.statusManager.SetPodStatus(pod, apiPodStatus)
+
+	pf.statusManager.TerminatePod(pod)
+	klog.V(4).InfoS("Pod is terminated and will need no more status updates", "pod", klog.KObj(pod), "podUID", pod.UID)
+
+	// 已经被删除的pod不会再启动，删除运行时中的记录；没有被删除的pod（比如已经完成的Job）保留记录，
+	// 以便还能读取容器的日志，等pod被删除后由housekeeping清理
+	if pod.DeletionTimestamp != nil {
+		if err := pf.containerRuntime.RemovePod(pod.UID); err != nil {
+			klog.ErrorS(err, "Unable to remove terminated pod from runtime", "pod", klog.KObj(pod), "podUID", pod.UID)
+		}
+	}
 	return nil
 }
 
-func (pf *PodFn) SyncPodFn(ctx context.Context, updateType kubetypes.SyncPodType, pod *v1.Pod, mirrorPod *v1.Pod, podStatus *kubecontainer.PodStatus) (bool, error) {
-	fmt.Println("进入同步过程", updateType)
-	pod_status := pf.generateAPIPodStatus(pod, podStatus)
-	pf.statusManager.SetPodStatus(pod, pod_status)
-	//pf.probeManager.AddPod(pod)
-	//if updateType == kubetypes.SyncPodCreate || updateType == kubetypes.SyncPodUpdate {
-	//	if pod.Name == "nginx-kubelet" {
-	//		podStatus := pf.generateAPIPodStatus(pod, podStatus)
-	//		pod.Status = podStatus
-	//
-	//		_, err := pf.kubeClient.CoreV1().Pods(pod.Namespace).
-	//			UpdateStatus(ctx, pod, metav1.UpdateOptions{})
-	//		if err != nil {
-	//			log.Println("SyncPodFn:", err)
-	//		} else {
-	//			pf.statusManager.SetPodStatus(pod, podStatus)
-	//		}
-	//	}
-	//}
+// runningContainerIDs 返回pod中仍在运行的容器ID
+func runningContainerIDs(podStatus *kubecontainer.PodStatus) []string {
+	var runningContainers []string
+	for _, s := range podStatus.ContainerStatuses {
+		if s.State == kubecontainer.ContainerStateRunning {
+			runningContainers = append(runningContainers, s.ID.String())
+		}
+	}
+	return runningContainers
+}
 
-	return true, nil
+// SyncPodFn 生成并更新pod状态，然后交给容器运行时启动pod中的容器。
+// 返回值为true时表示pod已经处于终态，pod worker会开始停止pod
+func (pf *PodFn) SyncPodFn(ctx context.Context, updateType kubetypes.SyncPodType, pod *v1.Pod, mirrorPod *v1.Pod, podStatus *kubecontainer.PodStatus) (isTerminal bool, err error) {
+	klog.V(4).InfoS("syncPod enter", "pod", klog.KObj(pod), "podUID", pod.UID, "updateType", updateType)
+	defer func() {
+		klog.V(4).InfoS("syncPod exit", "pod", klog.KObj(pod), "podUID", pod.UID, "isTerminal", isTerminal)
+	}()
+
+	// kubelet第一次看到pod的时间，由pod config写在注解中
+	var firstSeenTime time.Time
+	if firstSeenTimeStr, ok := pod.Annotations[kubetypes.ConfigFirstSeenAnnotationKey]; ok {
+		firstSeenTime = kubetypes.ConvertToTimestamp(firstSeenTimeStr).Get()
+	}
+
+	apiPodStatus := pf.generateAPIPodStatus(pod, podStatus)
+	// pod从Pending变为Running时记录从kubelet看到pod到pod运行的耗时，只启动了init容器或者容器启动失败时pod仍然是Pending
+	existingStatus, ok := pf.statusManager.GetPodStatus(pod.UID)
+	if ok && existingStatus.Phase == v1.PodPending && apiPodStatus.Phase == v1.PodRunning && !firstSeenTime.IsZero() {
+		metrics.PodStartDuration.Observe(metrics.SinceInSeconds(firstSeenTime))
+	}
+	pf.statusManager.SetPodStatus(pod, apiPodStatus)
+
+	// pod已经处于终态，不再启动任何容器
+	if apiPodStatus.Phase == v1.PodSucceeded || apiPodStatus.Phase == v1.PodFailed {
+		isTerminal = true
+		return isTerminal, nil
+	}
+
+	// 启动容器之前准备好pod的卷
+	if err := pf.volumeManager.MountVolumesForPod(pod); err != nil {
+		pf.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedMountVolume, "Unable to attach or mount volumes: %v", err)
+		klog.ErrorS(err, "Unable to attach or mount volumes for pod; skipping pod", "pod", klog.KObj(pod))
+		return false, err
+	}
+
+	// 为pod中的容器启动探针worker，已经存在的不会重复创建
+	pf.probeManager.AddPod(pod)
+
+	result := pf.containerRuntime.SyncPod(pod, podStatus, nil, pf.backOff)
+	pf.reasonCache.Update(pod.UID, result)
+	if err := result.Error(); err != nil {
+		// Do not return error if the only failures were pods in backoff
+		for _, r := range result.SyncResults {
+			if r.Error != kubecontainer.ErrCrashLoopBackOff {
+				// Do not record an event here, as we keep all event logging for sync pod failures
+				// local to container runtime, so we get better errors.
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	return false, nil
 }
 
 const (
@@ -346,6 +489,14 @@ func (pf *PodFn) convertStatusToAPIStatus(pod *v1.Pod, podStatus *kubecontainer.
 		podIPs[j] = ip
 	}
 
+	apiPodStatus.PodIPs = make([]v1.PodIP, 0, len(podIPs))
+	for _, ip := range podIPs {
+		apiPodStatus.PodIPs = append(apiPodStatus.PodIPs, v1.PodIP{IP: ip})
+	}
+	if len(apiPodStatus.PodIPs) > 0 {
+		apiPodStatus.PodIP = apiPodStatus.PodIPs[0].IP
+	}
+
 	apiPodStatus.ContainerStatuses = pf.convertToAPIContainerStatuses(
 		pod, podStatus,
 		oldPodStatus.ContainerStatuses,
@@ -437,6 +588,14 @@ func (pf *PodFn) generateAPIPodStatus(pod *v1.Pod, podStatus *kubecontainer.PodS
 	})
 
 	// set HostIP and initialize PodIP/PodIPs for host network pods
+	// 进程运行时中所有pod都使用宿主机网络，HostIP与PodIP一致
+	if s.PodIP != "" {
+		s.HostIP = s.PodIP
+	} else if oldPodStatus.PodIP != "" {
+		s.HostIP = oldPodStatus.HostIP
+		s.PodIP = oldPodStatus.PodIP
+		s.PodIPs = oldPodStatus.PodIPs
+	}
 
 	return *s
 }
@@ -556,14 +715,3 @@ func getPhase(spec *v1.PodSpec, info []v1.ContainerStatus) v1.PodPhase {
 		return v1.PodPending
 	}
 }
-
-type CmdRunner struct {
-}
-
-func (c *CmdRunner) RunInContainer(id kubecontainer.ContainerID, cmd []string, timeout time.Duration) ([]byte, error) {
-	//TODO implement me
-	return nil, nil
-}
-
-var _ kubecontainer.CommandRunner = &CmdRunner{}
-
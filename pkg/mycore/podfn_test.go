@@ -0,0 +1,164 @@
+package mycore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/metrics"
+	kubepod "k8s.io/kubernetes/pkg/kubelet/pod"
+	"k8s.io/kubernetes/pkg/kubelet/prober/results"
+	"k8s.io/kubernetes/pkg/kubelet/process"
+	"k8s.io/kubernetes/pkg/kubelet/status"
+	"k8s.io/kubernetes/pkg/kubelet/token"
+	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/pkg/kubelet/volumemanager"
+)
+
+// fakeRuntimeHelper runs containers with no environment and keeps pod
+// directories under dir.
+type fakeRuntimeHelper struct {
+	dir string
+}
+
+func (h fakeRuntimeHelper) GenerateRunContainerOptions(pod *v1.Pod, container *v1.Container, podIP string, podIPs []string) (*kubecontainer.RunContainerOptions, func(), error) {
+	return &kubecontainer.RunContainerOptions{}, nil, nil
+}
+
+func (h fakeRuntimeHelper) GetPodDNS(pod *v1.Pod) (*runtimeapi.DNSConfig, error) {
+	return nil, nil
+}
+
+func (h fakeRuntimeHelper) GetPodCgroupParent(pod *v1.Pod) string {
+	return ""
+}
+
+func (h fakeRuntimeHelper) GetPodDir(podUID types.UID) string {
+	return filepath.Join(h.dir, string(podUID))
+}
+
+func (h fakeRuntimeHelper) GeneratePodHostNameAndDomain(pod *v1.Pod) (string, string, error) {
+	return "", "", nil
+}
+
+func (h fakeRuntimeHelper) GetExtraSupplementalGroupsForPod(pod *v1.Pod) []int64 {
+	return nil
+}
+
+// newTestPodFn returns a PodFn backed by a real process runtime and an
+// unstarted status manager.
+func newTestPodFn(t *testing.T) *PodFn {
+	t.Helper()
+	dir := t.TempDir()
+	recorder := record.NewFakeRecorder(100)
+	lm, rm, sm := results.NewManager(), results.NewManager(), results.NewManager()
+	statusManager := status.NewManager(fake.NewSimpleClientset(), kubepod.NewBasicPodManager(nil, nil, nil), &PodDeletionSafetyProviderStruct{})
+	volumeManager := volumemanager.NewVolumeManager(dir, nil, nil, token.NewManager(nil))
+	runtime := process.NewProcessRuntime(recorder, fakeRuntimeHelper{dir: dir}, lm, sm, 1<<20, 2)
+	return NewPodFn(nil, statusManager, recorder, runtime, runtime, volumeManager, lm, rm, sm)
+}
+
+func TestSyncPodFnRecordsPodStartDuration(t *testing.T) {
+	metrics.Register()
+
+	testCases := []struct {
+		name           string
+		initContainers []v1.Container
+		containers     []v1.Container
+		// expectRunning whether the pod reaches Running, otherwise it is synced
+		// for a while and must stay pending
+		expectRunning bool
+	}{
+		{
+			name:           "init container runs first",
+			initContainers: []v1.Container{{Name: "init", Command: []string{"sleep", "0.5"}}},
+			containers:     []v1.Container{{Name: "app", Command: []string{"sleep", "1000"}}},
+			expectRunning:  true,
+		},
+		{
+			// syncs succeed because the CrashLoopBackOff error is swallowed
+			name:           "init container keeps failing",
+			initContainers: []v1.Container{{Name: "init", Command: []string{"sh", "-c", "exit 1"}}},
+			containers:     []v1.Container{{Name: "app", Command: []string{"sleep", "1000"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pf := newTestPodFn(t)
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "start",
+					Namespace:   "default",
+					UID:         types.UID("start-" + tc.name),
+					Annotations: map[string]string{kubetypes.ConfigFirstSeenAnnotationKey: kubetypes.NewTimestamp().GetString()},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy:  v1.RestartPolicyAlways,
+					InitContainers: tc.initContainers,
+					Containers:     tc.containers,
+				},
+			}
+			t.Cleanup(func() {
+				if err := pf.containerRuntime.KillPod(pod, kubecontainer.Pod{ID: pod.UID}, nil); err != nil {
+					t.Errorf("failed to kill pod: %v", err)
+				}
+			})
+
+			before, err := testutil.GetHistogramMetricCount(metrics.PodStartDuration.ObserverMetric)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// sync a few more times after the pod is running: the duration must be
+			// recorded only once, when the phase changes from Pending to Running
+			timeout := 2 * time.Second
+			if tc.expectRunning {
+				timeout = 10 * time.Second
+			}
+			var runningSyncs int
+			for deadline := time.Now().Add(timeout); runningSyncs < 3 && time.Now().Before(deadline); {
+				podStatus, err := pf.containerRuntime.GetPodStatus(pod.UID, pod.Name, pod.Namespace)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := pf.SyncPodFn(context.Background(), kubetypes.SyncPodSync, pod, nil, podStatus); err != nil {
+					t.Fatalf("unexpected sync error: %v", err)
+				}
+				apiStatus, _ := pf.statusManager.GetPodStatus(pod.UID)
+				observed, err := testutil.GetHistogramMetricCount(metrics.PodStartDuration.ObserverMetric)
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch apiStatus.Phase {
+				case v1.PodPending:
+					if observed != before {
+						t.Fatalf("expected no pod start to be recorded while the pod is pending, got %d", observed-before)
+					}
+				case v1.PodRunning:
+					runningSyncs++
+					if observed != before+1 {
+						t.Fatalf("expected 1 pod start to be recorded once the pod is running, got %d", observed-before)
+					}
+				default:
+					t.Fatalf("unexpected phase %q", apiStatus.Phase)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			if tc.expectRunning && runningSyncs == 0 {
+				t.Fatal("timed out waiting for the pod to be running")
+			}
+			if !tc.expectRunning && runningSyncs > 0 {
+				t.Fatal("expected the pod to stay pending")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package mycore
 
 import (
+	"fmt"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
@@ -13,13 +14,59 @@ import (
 	"k8s.io/kubernetes/pkg/kubelet/config"
 	"k8s.io/kubernetes/pkg/kubelet/configmap"
 	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/pleg"
 	kubepod "k8s.io/kubernetes/pkg/kubelet/pod"
+	"k8s.io/kubernetes/pkg/kubelet/prober/results"
+	"k8s.io/kubernetes/pkg/kubelet/process"
 	"k8s.io/kubernetes/pkg/kubelet/secret"
 	"k8s.io/kubernetes/pkg/kubelet/status"
+	"k8s.io/kubernetes/pkg/kubelet/token"
 	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/pkg/kubelet/util/manager"
+	"k8s.io/kubernetes/pkg/kubelet/util/queue"
+	"k8s.io/kubernetes/pkg/kubelet/volumemanager"
 	"k8s.io/utils/clock"
+	"time"
 )
 
+const (
+	// plegChannelCapacity PLEG事件channel的容量
+	plegChannelCapacity = 1000
+	// plegRelistPeriod PLEG relist的周期
+	plegRelistPeriod = time.Second * 1
+	// objectResyncInterval watch方式下configMap和secret的resync周期
+	objectResyncInterval = time.Minute
+)
+
+// ResourceChangeDetectionStrategy configMap和secret的获取方式，与官方kubelet的同名配置一致
+type ResourceChangeDetectionStrategy string
+
+const (
+	// GetChangeDetectionStrategy 每次都直接从apiserver获取
+	GetChangeDetectionStrategy ResourceChangeDetectionStrategy = "Get"
+	// TTLCacheChangeDetectionStrategy 带TTL的缓存，TTL来自node的node.alpha.kubernetes.io/ttl注解
+	TTLCacheChangeDetectionStrategy ResourceChangeDetectionStrategy = "Cache"
+	// WatchChangeDetectionStrategy 为pod引用的对象建立watch，immutable的对象不再watch
+	WatchChangeDetectionStrategy ResourceChangeDetectionStrategy = "Watch"
+)
+
+// newSecretAndConfigMapManagers 按照策略创建secret和configMap的manager
+func newSecretAndConfigMapManagers(client *kubernetes.Clientset, strategy ResourceChangeDetectionStrategy,
+	getNode func() (*v1.Node, error)) (secret.Manager, configmap.Manager, error) {
+	switch strategy {
+	case GetChangeDetectionStrategy:
+		return secret.NewSimpleSecretManager(client), configmap.NewSimpleConfigMapManager(client), nil
+	case TTLCacheChangeDetectionStrategy:
+		getTTL := manager.GetObjectTTLFromNodeFunc(getNode)
+		return secret.NewCachingSecretManager(client, getTTL), configmap.NewCachingConfigMapManager(client, getTTL), nil
+	case WatchChangeDetectionStrategy:
+		return secret.NewWatchingSecretManager(client, objectResyncInterval),
+			configmap.NewWatchingConfigMapManager(client, objectResyncInterval), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown configmap and secret manager mode: %v", strategy)
+	}
+}
+
 // 就是官方的 PodManager  做一些改造
 type PodCache struct {
 	client     *kubernetes.Clientset
@@ -27,22 +74,35 @@ type PodCache struct {
 	PodWorkers PodWorkers
 	PodConfig  *config.PodConfig //  configCh file http  apiserver (重点是apiserver)
 
-	Clock         clock.RealClock     //时钟对象
-	InnerPodCache kubecontainer.Cache //内部 POD 对象 。存的是 POD 和 状态之间的对应关系
+	Clock         clock.RealClock                 //时钟对象
+	InnerPodCache kubecontainer.Cache             //内部 POD 对象 。存的是 POD 和 状态之间的对应关系
+	Runtime       process.ProcessRuntime          // 容器运行时
+	PLEG          pleg.PodLifecycleEventGenerator // pod生命周期事件生成器
+	WorkQueue     queue.WorkQueue                 // pod worker需要重新同步的pod，由syncLoop定期取出
+	PodFn         *PodFn                          // pod的同步函数，syncLoop需要其中的探针结果
+	VolumeManager volumemanager.VolumeManager     // pod的目录和卷
 
 }
 
 // 所谓的构造函数
-func NewPodCache(client *kubernetes.Clientset, nodeName string) *PodCache {
+func NewPodCache(client *kubernetes.Clientset, nodeName string, rootDir string,
+	changeDetectionStrategy ResourceChangeDetectionStrategy, containerLogMaxSize int64, containerLogMaxFiles int) (*PodCache, error) {
 	ch := make(chan struct{})
 	fact := informers.NewSharedInformerFactory(client, 0)
 	fact.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{})
+	// service用于生成容器中service相关的环境变量
+	serviceInformer := fact.Core().V1().Services()
+	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{})
 	fact.Start(ch)
 
 	nodeLister := fact.Core().V1().Nodes().Lister()
 	mirrorPodClient := kubepod.NewBasicMirrorClient(client, nodeName, nodeLister)
-	secretManager := secret.NewSimpleSecretManager(client)
-	configMapManager := configmap.NewSimpleConfigMapManager(client)
+	// configMap和secret卷的内容会随着对象的变化刷新，获取方式由changeDetectionStrategy决定
+	secretManager, configMapManager, err := newSecretAndConfigMapManagers(client, changeDetectionStrategy,
+		func() (*v1.Node, error) { return nodeLister.Get(nodeName) })
+	if err != nil {
+		return nil, err
+	}
 	podManager := kubepod.NewBasicPodManager(mirrorPodClient, secretManager, configMapManager)
 
 	cl := clock.RealClock{}
@@ -59,7 +119,21 @@ func NewPodCache(client *kubernetes.Clientset, nodeName string) *PodCache {
 	// 创建 status_manager
 	statusManager := status.NewManager(client, podManager, &PodDeletionSafetyProviderStruct{})
 	statusManager.Start()
-	pw := NewPodWorkers(innerPodCache, eventRecorder, cl, client, statusManager, podManager)
+	// 进程运行时，pod中的每个容器都是宿主机上的一个进程
+	// 存活、就绪、启动探针的结果，运行时根据存活和启动探针的结果重启容器
+	livenessManager, readinessManager, startupManager := results.NewManager(), results.NewManager(), results.NewManager()
+	// 卷管理器，pod的目录和卷都在rootDir下
+	// projected卷中的service account token通过TokenRequest申请
+	volumeManager := volumemanager.NewVolumeManager(rootDir, secretManager, configMapManager, token.NewManager(client))
+	// runtimeHelper 为运行时生成容器的环境变量和挂载，configMap和secret通过对应的manager获取
+	rh := newRuntimeHelper(client, eventRecorder, secretManager, configMapManager, volumeManager,
+		serviceInformer.Lister(), serviceInformer.Informer().HasSynced)
+	runtime := process.NewProcessRuntime(eventRecorder, rh, livenessManager, startupManager, containerLogMaxSize, containerLogMaxFiles)
+	pf := NewPodFn(client, statusManager, eventRecorder, runtime, runtime, volumeManager, livenessManager, readinessManager, startupManager)
+	workQueue := queue.NewBasicWorkQueue(cl)
+	pw := NewPodWorkers(innerPodCache, eventRecorder, cl, pf, podManager, workQueue)
+	// PLEG 定期relist运行时，把pod的真实状态写入innerPodCache
+	podLifecycleEventGenerator := pleg.NewGenericPLEG(runtime, plegChannelCapacity, plegRelistPeriod, innerPodCache, cl)
 
 	return &PodCache{
 		Clock:         cl,
@@ -68,7 +142,12 @@ func NewPodCache(client *kubernetes.Clientset, nodeName string) *PodCache {
 		PodConfig:     newPodConfig(nodeName, client, fact, eventRecorder),
 		PodWorkers:    pw,
 		InnerPodCache: innerPodCache,
-	}
+		Runtime:       runtime,
+		PLEG:          podLifecycleEventGenerator,
+		WorkQueue:     workQueue,
+		PodFn:         pf,
+		VolumeManager: volumeManager,
+	}, nil
 }
 
 // 创建PodConfig
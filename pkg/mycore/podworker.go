@@ -2,20 +2,18 @@ package mycore
 
 import (
 	"context"
-	"fmt"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 	"k8s.io/klog/v2"
 	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/metrics"
 	"k8s.io/utils/clock"
 
 	kubepod "k8s.io/kubernetes/pkg/kubelet/pod"
-	"k8s.io/kubernetes/pkg/kubelet/status"
 	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
 	"k8s.io/kubernetes/pkg/kubelet/util/queue"
 	"strings"
@@ -347,9 +345,7 @@ type podWorkers struct {
 }
 
 func NewPodWorkers(cache kubecontainer.Cache, recorder record.EventRecorder, cl clock.RealClock,
-	client *kubernetes.Clientset, statusManager status.Manager, pm kubepod.Manager) PodWorkers {
-	wque := queue.NewBasicWorkQueue(cl)
-	pn := NewPodFn(client, statusManager, recorder)
+	pn *PodFn, pm kubepod.Manager, workQueue queue.WorkQueue) PodWorkers {
 	return &podWorkers{
 		podSyncStatuses:                    map[types.UID]*podSyncStatus{},
 		podUpdates:                         map[types.UID]chan podWork{},
@@ -360,7 +356,7 @@ func NewPodWorkers(cache kubecontainer.Cache, recorder record.EventRecorder, cl
 		syncTerminatingPodFn:               pn.SyncTerminatingFn,
 		syncTerminatedPodFn:                pn.SyncTerminatedFn,
 		recorder:                           recorder,
-		workQueue:                          wque,
+		workQueue:                          workQueue,
 		resyncInterval:                     time.Second * 1, // 写死1秒
 		backOffPeriod:                      time.Second * 10,
 		podCache:                           cache,
@@ -797,16 +793,6 @@ func (p *podWorkers) allowStaticPodStart(fullname string, uid types.UID) bool {
 	return true
 }
 
-func insertPodCache(podid types.UID, pm kubepod.Manager, podCache kubecontainer.Cache) error {
-	getPod, found := pm.GetPodByUID(types.UID(podid))
-	if !found {
-		return fmt.Errorf("pod not found")
-	}
-	podStatus := SetPodStatus(getPod, kubecontainer.ContainerStateRunning)
-	podCache.Set(podid, podStatus, nil, time.Now())
-	return nil
-}
-
 // managePodLoop 方法
 func (p *podWorkers) managePodLoop(podUpdates <-chan podWork) {
 
@@ -814,30 +800,9 @@ func (p *podWorkers) managePodLoop(podUpdates <-chan podWork) {
 	var podStarted bool
 	for update := range podUpdates {
 		pod := update.Options.Pod
-		//fmt.Printf("要处理的POD名称是:%s,ID是:%s,phase是:%s\n", pod.Name, pod.UID, pod.Status.Phase)
-		//insertErr := insertPodCache(pod.UID, p.podManager, p.podCache)
-		//if insertErr != nil {
-		//	fmt.Printf("插入缓存失败:%s\n", insertErr)
-		//} else {
-		//	if p.OnPreAdd != nil {
-		//		onaddErr := p.OnPreAdd(pod)
-		//		if onaddErr != nil {
-		//			fmt.Printf("执行onadd失败:%s\n", onaddErr)
-		//		}
-		//	}
-		//}
-		if !podStarted {
-			fmt.Printf("要处理的POD名称是:%s,ID是:%s,phase是:%s\n", pod.Name, pod.UID, pod.Status.Phase)
-			insertErr := insertPodCache(pod.UID, p.podManager, p.podCache)
-			if insertErr != nil {
-				fmt.Printf("插入缓存失败:%s\n", insertErr)
-			} else {
-				if p.OnPreAdd != nil {
-					onaddErr := p.OnPreAdd(pod)
-					if onaddErr != nil {
-						fmt.Printf("执行onadd失败:%s\n", onaddErr)
-					}
-				}
+		if !podStarted && p.OnPreAdd != nil {
+			if err := p.OnPreAdd(pod); err != nil {
+				klog.ErrorS(err, "OnPreAdd failed", "pod", klog.KObj(pod), "podUID", pod.UID)
 			}
 		}
 		// Decide whether to start the pod. If the pod was terminated prior to the pod being allowed
@@ -847,6 +812,7 @@ func (p *podWorkers) managePodLoop(podUpdates <-chan podWork) {
 			if !canEverStart {
 				p.completeUnstartedTerminated(pod)
 				if start := update.Options.StartTime; !start.IsZero() {
+					metrics.PodWorkerDuration.WithLabelValues(update.Options.UpdateType.String()).Observe(metrics.SinceInSeconds(start))
 				}
 				klog.V(4).InfoS("Processing pod event done", "pod", klog.KObj(pod), "podUID", pod.UID, "updateType", update.WorkType)
 				return
@@ -926,11 +892,13 @@ func (p *podWorkers) managePodLoop(podUpdates <-chan podWork) {
 		case err != nil:
 			// we will queue a retry
 			klog.ErrorS(err, "Error syncing pod, skipping", "pod", klog.KObj(pod), "podUID", pod.UID)
+			metrics.PodWorkerSyncErrors.WithLabelValues(update.Options.UpdateType.String()).Inc()
 
 		case update.WorkType == TerminatedPodWork:
 			// we can shut down the worker
 			p.completeTerminated(pod)
 			if start := update.Options.StartTime; !start.IsZero() {
+				metrics.PodWorkerDuration.WithLabelValues(update.Options.UpdateType.String()).Observe(metrics.SinceInSeconds(start))
 			}
 			klog.V(4).InfoS("Processing pod event done", "pod", klog.KObj(pod), "podUID", pod.UID, "updateType", update.WorkType)
 			return
@@ -957,6 +925,7 @@ func (p *podWorkers) managePodLoop(podUpdates <-chan podWork) {
 		// queue a retry if necessary, then put the next event in the channel if any
 		p.completeWork(pod, phaseTransition, err)
 		if start := update.Options.StartTime; !start.IsZero() {
+			metrics.PodWorkerDuration.WithLabelValues(update.Options.UpdateType.String()).Observe(metrics.SinceInSeconds(start))
 		}
 		klog.V(4).InfoS("Processing pod event done", "pod", klog.KObj(pod), "podUID", pod.UID, "updateType", update.WorkType)
 	}
@@ -0,0 +1,446 @@
+package mycore
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/api/v1/resource"
+	podshelper "k8s.io/kubernetes/pkg/apis/core/pods"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+	"k8s.io/kubernetes/pkg/fieldpath"
+	"k8s.io/kubernetes/pkg/kubelet/configmap"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/envvars"
+	"k8s.io/kubernetes/pkg/kubelet/secret"
+	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/pkg/kubelet/volumemanager"
+	"k8s.io/kubernetes/third_party/forked/golang/expansion"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// masterServices 无论是否开启enableServiceLinks，都会注入到容器环境变量中的service
+var masterServices = sets.NewString("kubernetes")
+
+// runtimeHelper 为容器运行时提供启动容器需要的信息，主要是容器的环境变量和卷的挂载
+type runtimeHelper struct {
+	kubeClient       kubernetes.Interface
+	recorder         record.EventRecorder
+	secretManager    secret.Manager
+	configMapManager configmap.Manager
+	// volumeManager 提供pod的目录和已经准备好的卷
+	volumeManager volumemanager.VolumeManager
+	// serviceLister、serviceHasSynced 用于生成service相关的环境变量
+	serviceLister    corelisters.ServiceLister
+	serviceHasSynced func() bool
+	// masterServiceNamespace kubernetes service所在的namespace
+	masterServiceNamespace string
+}
+
+var _ kubecontainer.RuntimeHelper = &runtimeHelper{}
+
+func newRuntimeHelper(client kubernetes.Interface, recorder record.EventRecorder, secretManager secret.Manager,
+	configMapManager configmap.Manager, volumeManager volumemanager.VolumeManager,
+	serviceLister corelisters.ServiceLister, serviceHasSynced func() bool) *runtimeHelper {
+	return &runtimeHelper{
+		kubeClient:             client,
+		recorder:               recorder,
+		secretManager:          secretManager,
+		configMapManager:       configMapManager,
+		volumeManager:          volumeManager,
+		serviceLister:          serviceLister,
+		serviceHasSynced:       serviceHasSynced,
+		masterServiceNamespace: v1.NamespaceDefault,
+	}
+}
+
+// GenerateRunContainerOptions 生成启动容器需要的选项，进程运行时只使用其中的环境变量和挂载
+func (h *runtimeHelper) GenerateRunContainerOptions(pod *v1.Pod, container *v1.Container, podIP string, podIPs []string) (*kubecontainer.RunContainerOptions, func(), error) {
+	mounts, err := h.makeMounts(pod, container)
+	if err != nil {
+		return nil, nil, err
+	}
+	envs, err := h.makeEnvironmentVariables(pod, container, podIP, podIPs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &kubecontainer.RunContainerOptions{Envs: envs, Mounts: mounts}, nil, nil
+}
+
+// makeMounts 根据容器的volumeMounts和已经准备好的卷生成挂载信息，subPath不存在时会被创建
+func (h *runtimeHelper) makeMounts(pod *v1.Pod, container *v1.Container) ([]kubecontainer.Mount, error) {
+	podVolumes := h.volumeManager.GetMountedVolumesForPod(pod.UID)
+	mounts := []kubecontainer.Mount{}
+	for _, mount := range container.VolumeMounts {
+		hostPath, ok := podVolumes[mount.Name]
+		if !ok {
+			return nil, fmt.Errorf("cannot find volume %q to mount into container %q", mount.Name, container.Name)
+		}
+		if subPath := mount.SubPath; subPath != "" {
+			if filepath.IsAbs(subPath) {
+				return nil, fmt.Errorf("error SubPath `%s` must not be an absolute path", subPath)
+			}
+			for _, item := range strings.Split(filepath.ToSlash(subPath), "/") {
+				if item == ".." {
+					return nil, fmt.Errorf("unable to provision SubPath `%s`: must not contain '..'", subPath)
+				}
+			}
+			hostPath = filepath.Join(hostPath, subPath)
+			if _, err := os.Stat(hostPath); os.IsNotExist(err) {
+				if err := os.MkdirAll(hostPath, 0750); err != nil {
+					return nil, fmt.Errorf("failed to create subPath directory for volumeMount %q of container %q: %v", mount.Name, container.Name, err)
+				}
+			}
+		}
+		mounts = append(mounts, kubecontainer.Mount{
+			Name:          mount.Name,
+			ContainerPath: mount.MountPath,
+			HostPath:      hostPath,
+			ReadOnly:      mount.ReadOnly,
+		})
+	}
+	return mounts, nil
+}
+
+// GetPodDNS 进程直接使用宿主机的DNS配置
+func (h *runtimeHelper) GetPodDNS(pod *v1.Pod) (*runtimeapi.DNSConfig, error) {
+	return nil, nil
+}
+
+// GetPodCgroupParent 进程运行时不为pod创建cgroup
+func (h *runtimeHelper) GetPodCgroupParent(pod *v1.Pod) string {
+	return ""
+}
+
+// GetPodDir 返回pod在宿主机上的目录
+func (h *runtimeHelper) GetPodDir(podUID types.UID) string {
+	return h.volumeManager.GetPodDir(podUID)
+}
+
+// GeneratePodHostNameAndDomain 进程运行在宿主机的网络中，hostname与宿主机相同，这里只返回pod期望的hostname
+func (h *runtimeHelper) GeneratePodHostNameAndDomain(pod *v1.Pod) (string, string, error) {
+	hostname := pod.Name
+	if len(pod.Spec.Hostname) > 0 {
+		if msgs := utilvalidation.IsDNS1123Label(pod.Spec.Hostname); len(msgs) != 0 {
+			return "", "", fmt.Errorf("pod Hostname %q is not a valid DNS label: %s", pod.Spec.Hostname, strings.Join(msgs, ";"))
+		}
+		hostname = pod.Spec.Hostname
+	}
+	return hostname, "", nil
+}
+
+// GetExtraSupplementalGroupsForPod 进程运行时不支持持久卷，没有额外的用户组
+func (h *runtimeHelper) GetExtraSupplementalGroupsForPod(pod *v1.Pod) []int64 {
+	return nil
+}
+
+// getServiceEnvVarMap makes a map[string]string of env vars for services a
+// pod in namespace ns should see.
+func (h *runtimeHelper) getServiceEnvVarMap(ns string, enableServiceLinks bool) (map[string]string, error) {
+	var (
+		serviceMap = make(map[string]*v1.Service)
+		m          = make(map[string]string)
+	)
+
+	// Get all service resources from the master (via a cache),
+	// and populate them into service environment variables.
+	if h.serviceLister == nil {
+		// Kubelets without masters (e.g. plain GCE ContainerVM) don't set env vars.
+		return m, nil
+	}
+	services, err := h.serviceLister.List(labels.Everything())
+	if err != nil {
+		return m, fmt.Errorf("failed to list services when setting up env vars")
+	}
+
+	// project the services in namespace ns onto the master services
+	for i := range services {
+		service := services[i]
+		// ignore services where ClusterIP is "None" or empty
+		if !v1helper.IsServiceIPSet(service) {
+			continue
+		}
+		serviceName := service.Name
+
+		// We always want to add environment variabled for master services
+		// from the master service namespace, even if enableServiceLinks is false.
+		// We also add environment variables for other services in the same
+		// namespace, if enableServiceLinks is true.
+		if service.Namespace == h.masterServiceNamespace && masterServices.Has(serviceName) {
+			if _, exists := serviceMap[serviceName]; !exists {
+				serviceMap[serviceName] = service
+			}
+		} else if service.Namespace == ns && enableServiceLinks {
+			serviceMap[serviceName] = service
+		}
+	}
+
+	mappedServices := make([]*v1.Service, 0, len(serviceMap))
+	for key := range serviceMap {
+		mappedServices = append(mappedServices, serviceMap[key])
+	}
+
+	for _, e := range envvars.FromServices(mappedServices) {
+		m[e.Name] = e.Value
+	}
+	return m, nil
+}
+
+// makeEnvironmentVariables 生成容器的环境变量，处理顺序与官方kubelet一致：
+// 先处理envFrom，再处理env（env会覆盖envFrom中的同名变量），最后追加service相关的环境变量
+func (h *runtimeHelper) makeEnvironmentVariables(pod *v1.Pod, container *v1.Container, podIP string, podIPs []string) ([]kubecontainer.EnvVar, error) {
+	if pod.Spec.EnableServiceLinks == nil {
+		return nil, fmt.Errorf("nil pod.spec.enableServiceLinks encountered, cannot construct envvars")
+	}
+
+	// 来自apiserver的pod需要等service至少同步一次，否则容器中会缺少KUBERNETES_SERVICE_HOST等变量
+	if !kubetypes.IsStaticPod(pod) && h.serviceHasSynced != nil && !h.serviceHasSynced() {
+		return nil, fmt.Errorf("services have not yet been read at least once, cannot construct envvars")
+	}
+
+	var result []kubecontainer.EnvVar
+	// service的变化不会计算到容器的hash中，因此service变化后不会重启容器
+	serviceEnv, err := h.getServiceEnvVarMap(pod.Namespace, *pod.Spec.EnableServiceLinks)
+	if err != nil {
+		return result, err
+	}
+
+	var (
+		configMaps = make(map[string]*v1.ConfigMap)
+		secrets    = make(map[string]*v1.Secret)
+		tmpEnv     = make(map[string]string)
+	)
+
+	// Env will override EnvFrom variables.
+	// Process EnvFrom first then allow Env to replace existing values.
+	for _, envFrom := range container.EnvFrom {
+		switch {
+		case envFrom.ConfigMapRef != nil:
+			cm := envFrom.ConfigMapRef
+			name := cm.Name
+			configMap, ok := configMaps[name]
+			if !ok {
+				if h.kubeClient == nil {
+					return result, fmt.Errorf("couldn't get configMap %v/%v, no kubeClient defined", pod.Namespace, name)
+				}
+				optional := cm.Optional != nil && *cm.Optional
+				configMap, err = h.configMapManager.GetConfigMap(pod.Namespace, name)
+				if err != nil {
+					if errors.IsNotFound(err) && optional {
+						// ignore error when marked optional
+						continue
+					}
+					return result, err
+				}
+				configMaps[name] = configMap
+			}
+
+			invalidKeys := []string{}
+			for k, v := range configMap.Data {
+				if len(envFrom.Prefix) > 0 {
+					k = envFrom.Prefix + k
+				}
+				if errMsgs := utilvalidation.IsEnvVarName(k); len(errMsgs) != 0 {
+					invalidKeys = append(invalidKeys, k)
+					continue
+				}
+				tmpEnv[k] = v
+			}
+			if len(invalidKeys) > 0 {
+				sort.Strings(invalidKeys)
+				h.recorder.Eventf(pod, v1.EventTypeWarning, "InvalidEnvironmentVariableNames", "Keys [%s] from the EnvFrom configMap %s/%s were skipped since they are considered invalid environment variable names.", strings.Join(invalidKeys, ", "), pod.Namespace, name)
+			}
+		case envFrom.SecretRef != nil:
+			s := envFrom.SecretRef
+			name := s.Name
+			secret, ok := secrets[name]
+			if !ok {
+				if h.kubeClient == nil {
+					return result, fmt.Errorf("couldn't get secret %v/%v, no kubeClient defined", pod.Namespace, name)
+				}
+				optional := s.Optional != nil && *s.Optional
+				secret, err = h.secretManager.GetSecret(pod.Namespace, name)
+				if err != nil {
+					if errors.IsNotFound(err) && optional {
+						// ignore error when marked optional
+						continue
+					}
+					return result, err
+				}
+				secrets[name] = secret
+			}
+
+			invalidKeys := []string{}
+			for k, v := range secret.Data {
+				if len(envFrom.Prefix) > 0 {
+					k = envFrom.Prefix + k
+				}
+				if errMsgs := utilvalidation.IsEnvVarName(k); len(errMsgs) != 0 {
+					invalidKeys = append(invalidKeys, k)
+					continue
+				}
+				tmpEnv[k] = string(v)
+			}
+			if len(invalidKeys) > 0 {
+				sort.Strings(invalidKeys)
+				h.recorder.Eventf(pod, v1.EventTypeWarning, "InvalidEnvironmentVariableNames", "Keys [%s] from the EnvFrom secret %s/%s were skipped since they are considered invalid environment variable names.", strings.Join(invalidKeys, ", "), pod.Namespace, name)
+			}
+		}
+	}
+
+	// Determine the final values of variables:
+	//
+	// 1.  Determine the final value of each variable:
+	//     a.  If the variable's Value is set, expand the `$(var)` references to other
+	//         variables in the .Value field; the sources of variables are the declared
+	//         variables of the container and the service environment variables
+	//     b.  If a source is defined for an environment variable, resolve the source
+	// 2.  Create the container's environment in the order variables are declared
+	// 3.  Add remaining service environment vars
+	var (
+		mappingFunc = expansion.MappingFuncFor(tmpEnv, serviceEnv)
+	)
+	for _, envVar := range container.Env {
+		runtimeVal := envVar.Value
+		if runtimeVal != "" {
+			// Step 1a: expand variable references
+			runtimeVal = expansion.Expand(runtimeVal, mappingFunc)
+		} else if envVar.ValueFrom != nil {
+			// Step 1b: resolve alternate env var sources
+			switch {
+			case envVar.ValueFrom.FieldRef != nil:
+				runtimeVal, err = h.podFieldSelectorRuntimeValue(envVar.ValueFrom.FieldRef, pod, podIP, podIPs)
+				if err != nil {
+					return result, err
+				}
+			case envVar.ValueFrom.ResourceFieldRef != nil:
+				runtimeVal, err = containerResourceRuntimeValue(envVar.ValueFrom.ResourceFieldRef, pod, container)
+				if err != nil {
+					return result, err
+				}
+			case envVar.ValueFrom.ConfigMapKeyRef != nil:
+				cm := envVar.ValueFrom.ConfigMapKeyRef
+				name := cm.Name
+				key := cm.Key
+				optional := cm.Optional != nil && *cm.Optional
+				configMap, ok := configMaps[name]
+				if !ok {
+					if h.kubeClient == nil {
+						return result, fmt.Errorf("couldn't get configMap %v/%v, no kubeClient defined", pod.Namespace, name)
+					}
+					configMap, err = h.configMapManager.GetConfigMap(pod.Namespace, name)
+					if err != nil {
+						if errors.IsNotFound(err) && optional {
+							// ignore error when marked optional
+							continue
+						}
+						return result, err
+					}
+					configMaps[name] = configMap
+				}
+				runtimeVal, ok = configMap.Data[key]
+				if !ok {
+					if optional {
+						continue
+					}
+					return result, fmt.Errorf("couldn't find key %v in ConfigMap %v/%v", key, pod.Namespace, name)
+				}
+			case envVar.ValueFrom.SecretKeyRef != nil:
+				s := envVar.ValueFrom.SecretKeyRef
+				name := s.Name
+				key := s.Key
+				optional := s.Optional != nil && *s.Optional
+				secret, ok := secrets[name]
+				if !ok {
+					if h.kubeClient == nil {
+						return result, fmt.Errorf("couldn't get secret %v/%v, no kubeClient defined", pod.Namespace, name)
+					}
+					secret, err = h.secretManager.GetSecret(pod.Namespace, name)
+					if err != nil {
+						if errors.IsNotFound(err) && optional {
+							// ignore error when marked optional
+							continue
+						}
+						return result, err
+					}
+					secrets[name] = secret
+				}
+				runtimeValBytes, ok := secret.Data[key]
+				if !ok {
+					if optional {
+						continue
+					}
+					return result, fmt.Errorf("couldn't find key %v in Secret %v/%v", key, pod.Namespace, name)
+				}
+				runtimeVal = string(runtimeValBytes)
+			}
+		}
+
+		tmpEnv[envVar.Name] = runtimeVal
+	}
+
+	// Append the env vars
+	for k, v := range tmpEnv {
+		result = append(result, kubecontainer.EnvVar{Name: k, Value: v})
+	}
+
+	// Append service env vars.
+	for k, v := range serviceEnv {
+		// Accesses apiserver+Pods.
+		// So, the master may set service env vars, or kubelet may.  In case both are doing
+		// it, we skip the key from the kubelet-generated ones so we don't have duplicate
+		// env vars.
+		// TODO: remove this next line once all platforms use apiserver+Pods.
+		if _, present := tmpEnv[k]; !present {
+			result = append(result, kubecontainer.EnvVar{Name: k, Value: v})
+		}
+	}
+	return result, nil
+}
+
+// podFieldSelectorRuntimeValue returns the runtime value of the given
+// selector for a pod.
+func (h *runtimeHelper) podFieldSelectorRuntimeValue(fs *v1.ObjectFieldSelector, pod *v1.Pod, podIP string, podIPs []string) (string, error) {
+	internalFieldPath, _, err := podshelper.ConvertDownwardAPIFieldLabel(fs.APIVersion, fs.FieldPath, "")
+	if err != nil {
+		return "", err
+	}
+
+	if len(podIPs) > 0 {
+		podIP = podIPs[0]
+	}
+
+	switch internalFieldPath {
+	case "spec.nodeName":
+		return pod.Spec.NodeName, nil
+	case "spec.serviceAccountName":
+		return pod.Spec.ServiceAccountName, nil
+	case "status.hostIP":
+		// 进程运行在宿主机的网络中，pod的IP就是宿主机的IP
+		return podIP, nil
+	case "status.podIP":
+		return podIP, nil
+	case "status.podIPs":
+		return strings.Join(podIPs, ","), nil
+	}
+	return fieldpath.ExtractFieldPathAsString(pod, internalFieldPath)
+}
+
+// containerResourceRuntimeValue returns the value of the provided container resource
+func containerResourceRuntimeValue(fs *v1.ResourceFieldSelector, pod *v1.Pod, container *v1.Container) (string, error) {
+	containerName := fs.ContainerName
+	if len(containerName) == 0 {
+		return resource.ExtractContainerResourceValue(fs, container)
+	}
+	return resource.ExtractResourceValueByContainerName(fs, pod, containerName)
+}
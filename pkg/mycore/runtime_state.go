@@ -0,0 +1,45 @@
+package mycore
+
+import (
+	"fmt"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sync"
+)
+
+// healthCheckFnType 健康检查函数，返回false时err说明不健康的原因
+type healthCheckFnType func() (bool, error)
+
+type healthCheck struct {
+	name string
+	fn   healthCheckFnType
+}
+
+// runtimeState 记录kubelet内部各组件（比如PLEG）的健康状况
+type runtimeState struct {
+	sync.RWMutex
+	healthChecks []*healthCheck
+}
+
+// addHealthCheck 注册一个健康检查
+func (s *runtimeState) addHealthCheck(name string, f healthCheckFnType) {
+	s.Lock()
+	defer s.Unlock()
+	s.healthChecks = append(s.healthChecks, &healthCheck{name: name, fn: f})
+}
+
+// runtimeErrors 执行所有的健康检查，返回不健康的原因，全部健康时返回nil
+func (s *runtimeState) runtimeErrors() error {
+	s.RLock()
+	defer s.RUnlock()
+	errs := []error{}
+	for _, hc := range s.healthChecks {
+		if ok, err := hc.fn(); !ok {
+			errs = append(errs, fmt.Errorf("%s is not healthy: %v", hc.name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func newRuntimeState() *runtimeState {
+	return &runtimeState{}
+}
@@ -0,0 +1,197 @@
+package mycore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog/v2"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/server"
+	"net"
+	"time"
+)
+
+var _ server.HostInterface = &SampleKubelet{}
+
+// ListenAndServe 启动kubelet的HTTPS服务，kubectl logs等请求由apiserver转发到这里，此方法会阻塞。
+// auth不为nil时，所有请求都要经过认证和鉴权
+func (k *SampleKubelet) ListenAndServe(address net.IP, port uint, tlsOptions *server.TLSOptions, auth server.AuthInterface) {
+	server.ListenAndServeKubeletServer(k, k.summaryProvider, auth, address, port, tlsOptions)
+}
+
+// GetPods 返回绑定到本节点的所有pod，pod的状态使用status manager中最新的状态
+func (k *SampleKubelet) GetPods() []*v1.Pod {
+	pods := k.podCache.PodManager.GetPods()
+	for i, p := range pods {
+		if status, ok := k.podCache.PodFn.statusManager.GetPodStatus(p.UID); ok {
+			pods[i] = p.DeepCopy()
+			pods[i].Status = status
+		}
+	}
+	return pods
+}
+
+// GetRunningPods 返回运行时中正在运行的pod，只包含运行时知道的信息
+func (k *SampleKubelet) GetRunningPods() ([]*v1.Pod, error) {
+	pods, err := k.podCache.Runtime.GetPods(false)
+	if err != nil {
+		return nil, err
+	}
+	apiPods := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		apiPods = append(apiPods, pod.ToAPIPod())
+	}
+	return apiPods, nil
+}
+
+// GetPodByName 根据namespace和name查找pod
+func (k *SampleKubelet) GetPodByName(namespace, name string) (*v1.Pod, bool) {
+	return k.podCache.PodManager.GetPodByName(namespace, name)
+}
+
+// GetKubeletContainerLogs 根据pod的状态找到要读取日志的容器实例，previous时读取上一次退出的实例
+func (k *SampleKubelet) GetKubeletContainerLogs(ctx context.Context, podFullName, containerName string, logOptions *v1.PodLogOptions, stdout, stderr io.Writer) error {
+	name, namespace, err := kubecontainer.ParsePodFullName(podFullName)
+	if err != nil {
+		return fmt.Errorf("unable to parse pod full name %q: %v", podFullName, err)
+	}
+
+	pod, ok := k.GetPodByName(namespace, name)
+	if !ok {
+		return fmt.Errorf("pod %q cannot be found - no logs available", name)
+	}
+
+	podUID := pod.UID
+	if mirrorPod, ok := k.podCache.PodManager.GetMirrorPodByPod(pod); ok {
+		podUID = mirrorPod.UID
+	}
+	podStatus, found := k.podCache.PodFn.statusManager.GetPodStatus(podUID)
+	if !found {
+		// status manager中没有缓存时（比如kubelet刚重启），使用apiserver中的状态
+		podStatus = pod.Status
+	}
+
+	containerID, err := validateContainerLogStatus(pod.Name, &podStatus, containerName, logOptions.Previous)
+	if err != nil {
+		return err
+	}
+
+	// 先写0个字节，保证在等待容器输出之前，调用方的writer至少被调用过一次
+	if _, err := stdout.Write([]byte{}); err != nil {
+		return err
+	}
+
+	klog.V(4).InfoS("Reading container logs", "pod", klog.KObj(pod), "containerName", containerName, "containerID", containerID)
+	return k.podCache.Runtime.GetContainerLogs(ctx, pod, containerID, logOptions, stdout, stderr)
+}
+
+// ExecInContainer 在pod的容器中执行命令，把客户端的输入输出连接到命令上
+func (k *SampleKubelet) ExecInContainer(podFullName string, podUID types.UID, containerName string, cmd []string,
+	stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	container, err := k.findContainer(podFullName, podUID, containerName)
+	if err != nil {
+		return err
+	}
+	if container == nil {
+		return fmt.Errorf("container not found (%q)", containerName)
+	}
+	return k.podCache.Runtime.ExecInContainer(container.ID, cmd, stdin, stdout, stderr, tty, resize, timeout)
+}
+
+// AttachContainer 把客户端连接到pod中正在运行的容器进程上
+func (k *SampleKubelet) AttachContainer(podFullName string, podUID types.UID, containerName string,
+	stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	container, err := k.findContainer(podFullName, podUID, containerName)
+	if err != nil {
+		return err
+	}
+	if container == nil {
+		return fmt.Errorf("container not found (%q)", containerName)
+	}
+	return k.podCache.Runtime.AttachContainer(container.ID, stdin, stdout, stderr, tty, resize)
+}
+
+// PortForward 把stream连接到pod中的端口上
+func (k *SampleKubelet) PortForward(podFullName string, podUID types.UID, port int32, stream io.ReadWriteCloser) error {
+	pods, err := k.podCache.Runtime.GetPods(false)
+	if err != nil {
+		return err
+	}
+	podUID = types.UID(k.podCache.PodManager.TranslatePodUID(podUID))
+	pod := kubecontainer.Pods(pods).FindPod(podFullName, podUID)
+	if pod.IsEmpty() {
+		return fmt.Errorf("pod not found (%q)", podFullName)
+	}
+	return k.podCache.Runtime.PortForward(&pod, port, stream)
+}
+
+// findContainer 在运行时中查找pod中正在运行的容器，找不到时返回nil。
+// 请求中的UID可能是mirror pod的UID，需要转换为static pod的UID
+func (k *SampleKubelet) findContainer(podFullName string, podUID types.UID, containerName string) (*kubecontainer.Container, error) {
+	pods, err := k.podCache.Runtime.GetPods(false)
+	if err != nil {
+		return nil, err
+	}
+	podUID = types.UID(k.podCache.PodManager.TranslatePodUID(podUID))
+	pod := kubecontainer.Pods(pods).FindPod(podFullName, podUID)
+	return pod.FindContainerByName(containerName), nil
+}
+
+// validateContainerLogStatus returns the container ID for the desired container to retrieve logs for, based on the state
+// of the container. The previous flag will only return the logs for the last terminated container, otherwise, the current
+// running container is preferred over a previous termination. If info about the container is not available then a specific
+// error is returned to the end user.
+func validateContainerLogStatus(podName string, podStatus *v1.PodStatus, containerName string, previous bool) (containerID kubecontainer.ContainerID, err error) {
+	var cID string
+
+	cStatus, found := podutil.GetContainerStatus(podStatus.ContainerStatuses, containerName)
+	if !found {
+		cStatus, found = podutil.GetContainerStatus(podStatus.InitContainerStatuses, containerName)
+	}
+	if !found {
+		return kubecontainer.ContainerID{}, fmt.Errorf("container %q in pod %q is not available", containerName, podName)
+	}
+	lastState := cStatus.LastTerminationState
+	waiting, running, terminated := cStatus.State.Waiting, cStatus.State.Running, cStatus.State.Terminated
+
+	switch {
+	case previous:
+		if lastState.Terminated == nil || lastState.Terminated.ContainerID == "" {
+			return kubecontainer.ContainerID{}, fmt.Errorf("previous terminated container %q in pod %q not found", containerName, podName)
+		}
+		cID = lastState.Terminated.ContainerID
+
+	case running != nil:
+		cID = cStatus.ContainerID
+
+	case terminated != nil:
+		// in cases where the next container didn't start, terminated.ContainerID will be empty, so get logs from the lastState.Terminated.
+		if terminated.ContainerID == "" {
+			if lastState.Terminated != nil && lastState.Terminated.ContainerID != "" {
+				cID = lastState.Terminated.ContainerID
+			} else {
+				return kubecontainer.ContainerID{}, fmt.Errorf("container %q in pod %q is terminated", containerName, podName)
+			}
+		} else {
+			cID = terminated.ContainerID
+		}
+
+	case lastState.Terminated != nil:
+		if lastState.Terminated.ContainerID == "" {
+			return kubecontainer.ContainerID{}, fmt.Errorf("container %q in pod %q is terminated", containerName, podName)
+		}
+		cID = lastState.Terminated.ContainerID
+
+	case waiting != nil:
+		return kubecontainer.ContainerID{}, fmt.Errorf("container %q in pod %q is waiting to start: %v", containerName, podName, waiting.Reason)
+	default:
+		// unrecognized state
+		return kubecontainer.ContainerID{}, fmt.Errorf("container %q in pod %q is waiting to start - no logs yet", containerName, podName)
+	}
+
+	return kubecontainer.ParseContainerID(cID), nil
+}
@@ -6,8 +6,10 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientset "k8s.io/client-go/kubernetes"
+	coordclientset "k8s.io/client-go/kubernetes/typed/coordination/v1"
 	"k8s.io/component-helpers/apimachinery/lease"
 	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/kubelet/metrics"
 	"k8s.io/utils/clock"
 	"os"
 	"time"
@@ -56,7 +58,7 @@ func StartLeaseController(kubeCient clientset.Interface, nodeName string) {
 	}
 	klog.Infoln("starting lease controller")
 	ctl := lease.NewController(myClock,
-		kubeCient, nodeName, LeaseDurationSeconds,
+		leaseMetricsClient{kubeCient}, nodeName, LeaseDurationSeconds,
 		heartbeatFailure, renewInterval,
 		nodeName, LeaseNameSpace,
 		SetNodeOwnerFunc(kubeCient, nodeName))
@@ -64,3 +66,41 @@ func StartLeaseController(kubeCient clientset.Interface, nodeName string) {
 	// 此方法会阻塞
 	go ctl.Run(context.Background())
 }
+
+// leaseMetricsClient 包装clientset，租约控制器创建和更新租约失败时记录续期失败的次数，
+// lease.Controller只在多次失败后回调heartbeatFailure，单次失败只能从client上观察到
+type leaseMetricsClient struct {
+	clientset.Interface
+}
+
+func (c leaseMetricsClient) CoordinationV1() coordclientset.CoordinationV1Interface {
+	return leaseMetricsCoordinationClient{c.Interface.CoordinationV1()}
+}
+
+type leaseMetricsCoordinationClient struct {
+	coordclientset.CoordinationV1Interface
+}
+
+func (c leaseMetricsCoordinationClient) Leases(namespace string) coordclientset.LeaseInterface {
+	return leaseMetricsLeases{c.CoordinationV1Interface.Leases(namespace)}
+}
+
+type leaseMetricsLeases struct {
+	coordclientset.LeaseInterface
+}
+
+func (l leaseMetricsLeases) Create(ctx context.Context, lease *coordinationv1.Lease, opts metav1.CreateOptions) (*coordinationv1.Lease, error) {
+	created, err := l.LeaseInterface.Create(ctx, lease, opts)
+	if err != nil {
+		metrics.NodeLeaseRenewErrors.Inc()
+	}
+	return created, err
+}
+
+func (l leaseMetricsLeases) Update(ctx context.Context, lease *coordinationv1.Lease, opts metav1.UpdateOptions) (*coordinationv1.Lease, error) {
+	updated, err := l.LeaseInterface.Update(ctx, lease, opts)
+	if err != nil {
+		metrics.NodeLeaseRenewErrors.Inc()
+	}
+	return updated, err
+}
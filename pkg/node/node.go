@@ -12,8 +12,8 @@ import (
 	"runtime"
 )
 
-// RegisterNode 注册node
-func RegisterNode(nodeName string, client *kubernetes.Clientset) {
+// RegisterNode 注册node，kubeletPort是kubelet HTTPS服务的端口
+func RegisterNode(nodeName string, kubeletPort int32, client *kubernetes.Clientset) {
 	// node对象
 	node := &v1.Node{
 		ObjectMeta: metav1.ObjectMeta{
@@ -47,7 +47,7 @@ func RegisterNode(nodeName string, client *kubernetes.Clientset) {
 
 	}
 	newNode := nodeInstance.DeepCopy()
-	setNodeStatus(newNode)
+	setNodeStatus(newNode, kubeletPort)
 	// patch node的状态与其他信息
 	patchBytes, err := util.PreparePatchBytesforNodeStatus(types.NodeName(nodeName), node, newNode)
 	if err != nil {
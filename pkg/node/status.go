@@ -8,9 +8,9 @@ import (
 )
 
 // setNodeStatus 设置node状态
-func setNodeStatus(node *v1.Node) {
+func setNodeStatus(node *v1.Node, kubeletPort int32) {
 	node.Status.NodeInfo = nodeInfo()
-	node.Status.DaemonEndpoints = nodeDaemonEndpoints(10250)
+	node.Status.DaemonEndpoints = nodeDaemonEndpoints(kubeletPort)
 	node.Status.Addresses = nodeAddresses()
 	node.Status.Conditions = nodeConditions()
 	node.Status.Capacity = nodeCapacity()
@@ -17,8 +17,12 @@ limitations under the License.
 package exec
 
 import (
+	"bytes"
+
+	"k8s.io/kubernetes/pkg/kubelet/util/ioutils"
 	"k8s.io/kubernetes/pkg/probe"
 
+	"k8s.io/klog/v2"
 	"k8s.io/utils/exec"
 )
 
@@ -38,8 +42,38 @@ type Prober interface {
 
 type execProber struct{}
 
-// 不支持
+// Probe executes a command to check the liveness/readiness of container
+// from executing a command. Returns the Result status, command output, and
+// errors if any.
 func (pr execProber) Probe(e exec.Cmd) (probe.Result, string, error) {
+	var dataBuffer bytes.Buffer
+	writer := ioutils.LimitWriter(&dataBuffer, maxReadLength)
+
+	e.SetStderr(writer)
+	e.SetStdout(writer)
+	err := e.Start()
+	if err == nil {
+		err = e.Wait()
+	}
+	data := dataBuffer.Bytes()
+
+	klog.V(4).Infof("Exec probe response: %q", string(data))
+	if err != nil {
+		exit, ok := err.(exec.ExitError)
+		if ok {
+			if exit.ExitStatus() == 0 {
+				return probe.Success, string(data), nil
+			}
+			return probe.Failure, string(data), nil
+		}
+
+		timeoutErr, ok := err.(*TimeoutError)
+		if ok {
+			// When exec probe timeout, data is empty, so we should return timeoutErr.Error() as the stdout.
+			return probe.Failure, timeoutErr.Error(), nil
+		}
 
-	return probe.Success, string(""), nil
+		return probe.Unknown, "", err
+	}
+	return probe.Success, string(data), nil
 }
@@ -0,0 +1,172 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/kubernetes/pkg/probe"
+)
+
+// healthServerMock answers every Check with status after waiting for delay.
+type healthServerMock struct {
+	grpchealth.UnimplementedHealthServer
+
+	status grpchealth.HealthCheckResponse_ServingStatus
+	delay  time.Duration
+}
+
+func (s *healthServerMock) Check(ctx context.Context, req *grpchealth.HealthCheckRequest) (*grpchealth.HealthCheckResponse, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &grpchealth.HealthCheckResponse{Status: s.status}, nil
+}
+
+// startServer serves a gRPC server on a loopback port and returns the port.
+// When health is nil, the server does not implement grpc.health.v1.Health.
+func startServer(t *testing.T, health grpchealth.HealthServer) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := grpc.NewServer()
+	if health != nil {
+		grpchealth.RegisterHealthServer(s, health)
+	}
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestGrpcProber_Probe(t *testing.T) {
+	tests := []struct {
+		name         string
+		health       grpchealth.HealthServer
+		timeout      time.Duration
+		expectResult probe.Result
+		expectOutput string
+	}{
+		{
+			name:         "serving",
+			health:       &healthServerMock{status: grpchealth.HealthCheckResponse_SERVING},
+			timeout:      time.Second,
+			expectResult: probe.Success,
+			expectOutput: "service healthy",
+		},
+		{
+			name:         "not serving",
+			health:       &healthServerMock{status: grpchealth.HealthCheckResponse_NOT_SERVING},
+			timeout:      time.Second,
+			expectResult: probe.Failure,
+			expectOutput: `service unhealthy (responded with "NOT_SERVING")`,
+		},
+		{
+			name:         "unknown",
+			health:       &healthServerMock{status: grpchealth.HealthCheckResponse_UNKNOWN},
+			timeout:      time.Second,
+			expectResult: probe.Failure,
+			expectOutput: `service health unknown (responded with "UNKNOWN")`,
+		},
+		{
+			name:         "health protocol not implemented",
+			health:       nil,
+			timeout:      time.Second,
+			expectResult: probe.Failure,
+			expectOutput: "error: this server does not implement the grpc health protocol (grpc.health.v1.Health): unknown service grpc.health.v1.Health",
+		},
+		{
+			name:         "rpc timeout",
+			health:       &healthServerMock{status: grpchealth.HealthCheckResponse_SERVING, delay: time.Minute},
+			timeout:      500 * time.Millisecond,
+			expectResult: probe.Failure,
+			expectOutput: "timeout: health rpc did not complete within 500ms",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			port := startServer(t, tc.health)
+			result, output, err := New().Probe("127.0.0.1", "", port, tc.timeout)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tc.expectResult {
+				t.Errorf("expected result %q, got %q (output %q)", tc.expectResult, result, output)
+			}
+			if output != tc.expectOutput {
+				t.Errorf("expected output %q, got %q", tc.expectOutput, output)
+			}
+		})
+	}
+}
+
+func TestGrpcProber_ConnectTimeout(t *testing.T) {
+	// A TCP listener that accepts connections but never speaks HTTP/2, so the
+	// blocking dial cannot complete before the probe times out.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	result, output, err := New().Probe("127.0.0.1", "", port, 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != probe.Failure {
+		t.Errorf("expected result %q, got %q", probe.Failure, result)
+	}
+	if !strings.HasPrefix(output, "timeout: failed to connect service") {
+		t.Errorf("expected connect timeout output, got %q", output)
+	}
+}
+
+func TestGrpcProber_ConnectionRefused(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	result, _, err := New().Probe("127.0.0.1", "", port, 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != probe.Failure {
+		t.Errorf("expected result %q, got %q", probe.Failure, result)
+	}
+}